@@ -0,0 +1,291 @@
+package chat
+
+import (
+	"backend/db"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Attachment settings, overridden by Configure
+var (
+	attachmentDir           = "uploads/chat"
+	maxAttachmentSize int64 = 10 << 20
+	attachmentTypes         = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+	attachmentTTL           = 24 * time.Hour
+	pendingQuota      int64 = 50 << 20
+)
+
+// How often uploads that outlived attachmentTTL without being sent are removed
+const attachmentSweepInterval = 10 * time.Minute
+
+var errInvalidAttachment = errors.New("attachment not found or already used")
+
+// unexpired is the datetime() modifier for the oldest upload that may still be claimed
+func unexpired() string {
+	return fmt.Sprintf("-%d seconds", int(attachmentTTL.Seconds()))
+}
+
+// Attachment is an uploaded file waiting to be, or already, referenced by a message
+type Attachment struct {
+	ID        int    `json:"attachment_id"`
+	Media     string `json:"media"`
+	MediaType string `json:"media_type"`
+	Size      int64  `json:"size"`
+}
+
+// UploadAttachmentHandler stores a chat file sent as multipart field "file" and returns its id.
+// The type is sniffed from the content; the client's file name and Content-Type are not trusted.
+// An upload must be sent within attachmentTTL, and a user's unsent uploads may not exceed pendingQuota.
+func UploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	// Leave room for the multipart framing around the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	if err := r.ParseMultipartForm(maxAttachmentSize); err != nil {
+		log.Printf("[Attachment][ERROR] Failed to parse multipart form: %v", err)
+		http.Error(w, "File too large or malformed form", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxAttachmentSize {
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(file, sniff)
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+	if !attachmentTypeAllowed(mediaType) {
+		http.Error(w, "File type not allowed", http.StatusUnsupportedMediaType)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	if err := os.MkdirAll(attachmentDir, os.ModePerm); err != nil {
+		log.Printf("[Attachment][ERROR] Failed to create directory: %v", err)
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
+
+	// Files are served publicly under /uploads, so names must not be guessable
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
+	filePath := filepath.Join(attachmentDir, hex.EncodeToString(random)+attachmentExtension(mediaType))
+
+	dst, err := os.Create(filePath)
+	if err != nil {
+		log.Printf("[Attachment][ERROR] Failed to create file: %v", err)
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
+	size, err := io.Copy(dst, file)
+	dst.Close()
+	if err != nil {
+		os.Remove(filePath)
+		log.Printf("[Attachment][ERROR] Failed to write file: %v", err)
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
+
+	// The quota is checked by the insert itself, so parallel uploads can't all slip under it
+	result, err := db.Instance.Exec(`
+		INSERT INTO chat_attachments (uploader_id, file_path, mime_type, size_bytes, original_name)
+		SELECT ?, ?, ?, ?, ?
+		WHERE (SELECT COALESCE(SUM(size_bytes), 0) FROM chat_attachments
+		       WHERE uploader_id = ? AND message_id IS NULL AND created_at > datetime('now', ?)) + ? <= ?`,
+		userID, filePath, mediaType, size, filepath.Base(header.Filename),
+		userID, unexpired(), size, pendingQuota)
+	if err != nil {
+		os.Remove(filePath)
+		log.Printf("[Attachment][ERROR] Failed to record attachment: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		os.Remove(filePath)
+		http.Error(w, "Too many unsent attachments; send or wait for them to expire", http.StatusTooManyRequests)
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Attachment{ID: int(id), Media: filePath, MediaType: mediaType, Size: size})
+}
+
+func attachmentTypeAllowed(mediaType string) bool {
+	for _, allowed := range attachmentTypes {
+		if strings.EqualFold(allowed, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+func attachmentExtension(mediaType string) string {
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// resolveAttachment fills Media/MediaType on an outgoing message from its attachment id.
+// Only the uploader may use an attachment, only once, and only before it expires.
+func resolveAttachment(msg *Message) error {
+	msg.Media, msg.MediaType = "", ""
+	if msg.AttachmentID <= 0 {
+		return nil
+	}
+
+	err := db.Instance.QueryRow(`
+		SELECT file_path, mime_type FROM chat_attachments
+		WHERE attachment_id = ? AND uploader_id = ? AND message_id IS NULL AND created_at > datetime('now', ?)`,
+		msg.AttachmentID, msg.SenderID, unexpired()).Scan(&msg.Media, &msg.MediaType)
+	if err == sql.ErrNoRows {
+		return errInvalidAttachment
+	}
+	return err
+}
+
+// claimAttachment ties the attachment to the message it was sent with, in the same
+// transaction as the insert so two messages can't both claim it
+func claimAttachment(tx *sql.Tx, kind string, messageID int, msg Message) error {
+	if msg.AttachmentID <= 0 {
+		return nil
+	}
+
+	result, err := tx.Exec(`
+		UPDATE chat_attachments SET conversation_type = ?, message_id = ?
+		WHERE attachment_id = ? AND uploader_id = ? AND message_id IS NULL AND created_at > datetime('now', ?)`,
+		kind, messageID, msg.AttachmentID, msg.SenderID, unexpired())
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errInvalidAttachment
+	}
+	return nil
+}
+
+// unclaimAttachment hands a message's attachment back to its uploader, for a message that
+// was saved but never sent. It expires as if it had never been claimed.
+func unclaimAttachment(tx *sql.Tx, kind string, messageID int) error {
+	_, err := tx.Exec(`
+		UPDATE chat_attachments SET conversation_type = NULL, message_id = NULL
+		WHERE conversation_type = ? AND message_id = ?`,
+		kind, messageID)
+	return err
+}
+
+// releaseAttachment forgets a deleted message's attachment and returns its file for removal
+func releaseAttachment(tx *sql.Tx, kind string, messageID int) (string, error) {
+	var filePath string
+	err := tx.QueryRow(`SELECT file_path FROM chat_attachments WHERE conversation_type = ? AND message_id = ?`,
+		kind, messageID).Scan(&filePath)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`DELETE FROM chat_attachments WHERE conversation_type = ? AND message_id = ?`, kind, messageID)
+	return filePath, err
+}
+
+// SweepAttachments removes uploads that expired without being sent, now and every
+// attachmentSweepInterval after. It never returns.
+func SweepAttachments() {
+	ticker := time.NewTicker(attachmentSweepInterval)
+	defer ticker.Stop()
+	for {
+		if removed, err := sweepExpiredAttachments(); err != nil {
+			log.Printf("[Attachment][ERROR] Sweep failed: %v", err)
+		} else if removed > 0 {
+			log.Printf("[Attachment] Removed %d expired uploads", removed)
+		}
+		<-ticker.C
+	}
+}
+
+func sweepExpiredAttachments() (int, error) {
+	type expired struct {
+		id       int
+		filePath string
+	}
+	rows, err := db.Instance.Query(`
+		SELECT attachment_id, file_path FROM chat_attachments
+		WHERE message_id IS NULL AND created_at <= datetime('now', ?)`, unexpired())
+	if err != nil {
+		return 0, err
+	}
+	var found []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.filePath); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		found = append(found, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, e := range found {
+		// Claimed since the query ran: the row stays and so does the file
+		result, err := db.Instance.Exec(`DELETE FROM chat_attachments WHERE attachment_id = ? AND message_id IS NULL`, e.id)
+		if err != nil {
+			return removed, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		if err := os.Remove(e.filePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("[Attachment][ERROR] Failed to remove expired upload %s: %v", e.filePath, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
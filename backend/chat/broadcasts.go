@@ -8,79 +8,51 @@ import (
 )
 
 func BroadcastToGroupMembers(msg Message) {
+	broadcastToGroup(msg.GroupID, msg.SenderID, msg)
+}
+
+// broadcastToGroup queues a frame for every accepted member of a group except one user
+func broadcastToGroup(groupID, exceptUserID int, v Event) {
+	if frame, ok := encodeFrame(v); ok {
+		frame.ExceptUserID = exceptUserID
+		publishToGroup(groupID, frame)
+	}
+}
+
+// groupMemberIDs lists the accepted members of a group, leaving out exceptUserID
+func groupMemberIDs(groupID, exceptUserID int) []int {
 	// Get all group members
 	rows, err := db.Instance.Query(`
 		SELECT user_id FROM group_memberships 
 		WHERE group_id = ? AND status = 'accepted'
-	`, msg.GroupID)
+	`, groupID)
 	if err != nil {
 		log.Printf("Error getting group members: %v", err)
-		return
+		return nil
 	}
 	defer rows.Close()
 
-	ClientsMux.Lock()
+	var memberIDs []int
 	for rows.Next() {
 		var userID int
-		if err := rows.Scan(&userID); err == nil {
-			if client, exists := Clients[userID]; exists && userID != msg.SenderID {
-				client.Conn.WriteJSON(msg)
-			}
+		if err := rows.Scan(&userID); err == nil && userID != exceptUserID {
+			memberIDs = append(memberIDs, userID)
 		}
 	}
-	ClientsMux.Unlock()
+	return memberIDs
 }
 
 func BroadcastTypingToUser(msg Message) {
-	ClientsMux.Lock()
-	receiver, exists := Clients[msg.ReceiverID]
-	ClientsMux.Unlock()
-
-	if exists {
-		receiver.Conn.WriteJSON(msg)
+	if !canReach(msg.SenderID, msg.ReceiverID) {
+		return
 	}
+	trySendToUser(msg.ReceiverID, msg)
 }
 
 func BroadcastTypingToGroup(msg Message) {
-	ClientsMux.Lock()
-	for _, client := range Clients {
-		if client.ID != msg.SenderID {
-			// Check if user is in the group
-			for _, groupID := range client.Groups {
-				if groupID == msg.GroupID {
-					client.Conn.WriteJSON(msg)
-					break
-				}
-			}
-		}
-	}
-	ClientsMux.Unlock()
-}
-
-func BroadcastOnlineUsers() {
-	// Collect IDs of online users
-	ClientsMux.Lock()
-	onlineUsers := make([]int, 0, len(Clients))
-	for id := range Clients {
-		onlineUsers = append(onlineUsers, id)
-	}
-	// make a copy of Clients so we don’t hold lock during writes
-	copies := make([]*Client, 0, len(Clients))
-	for _, client := range Clients {
-		copies = append(copies, client)
-	}
-	ClientsMux.Unlock()
-
-	// Build the message
-	notification := map[string]interface{}{
-		"type":         "online_users",
-		"online_users": onlineUsers,
-	}
-
-	// Send to each client (outside the lock!)
-	for _, client := range copies {
-		if err := client.Conn.WriteJSON(notification); err != nil {
-			log.Printf("Error sending online users: %v", err)
-		}
+	if frame, ok := encodeFrame(msg); ok {
+		frame.ExceptUserID = msg.SenderID
+		frame.Droppable = true
+		publishToGroup(msg.GroupID, frame)
 	}
 }
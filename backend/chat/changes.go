@@ -0,0 +1,114 @@
+package chat
+
+import (
+	"backend/db"
+	"database/sql"
+	"time"
+)
+
+// recordChange logs an edit, delete or reaction in the same transaction as the change itself,
+// and returns its change_id: the position sync replays it from. emoji is only for reactions.
+func recordChange(tx *sql.Tx, kind string, messageID int, change string, actorID int, emoji string) (int, error) {
+	result, err := tx.Exec(`
+		INSERT INTO message_changes (conversation_type, message_id, change, actor_id, emoji)
+		VALUES (?, ?, ?, ?, ?)`,
+		kind, messageID, change, actorID, nullableString(emoji))
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// visibleChanges limits message_changes c to the conversations a user can sync: their private
+// messages, less requests to them that are not accepted, and groups they belong to. It joins
+// a for the actor and m or gm for the message, and takes the user id four times.
+const visibleChanges = `FROM message_changes c
+	JOIN users a ON a.id = c.actor_id
+	LEFT JOIN messages m ON c.conversation_type = 'user' AND m.message_id = c.message_id
+	LEFT JOIN group_messages gm ON c.conversation_type = 'group' AND gm.message_id = c.message_id
+	LEFT JOIN group_memberships gms ON gms.group_id = gm.group_id AND gms.user_id = ? AND gms.status = 'accepted'
+	WHERE ((m.sender_id = ? OR m.receiver_id = ?)
+	       AND NOT (m.receiver_id = ? AND m.message_id IN (SELECT message_id FROM message_requests WHERE status != 'accepted'))
+	       OR gms.user_id IS NOT NULL)`
+
+// latestChangeID is where a freshly connected client's change stream starts
+func latestChangeID(userID int) int {
+	var id int
+	db.Instance.QueryRow(`SELECT COALESCE(MAX(c.change_id), 0) `+visibleChanges, userID, userID, userID, userID).Scan(&id)
+	return id
+}
+
+// changesSince replays edits, deletes and reactions after a change_id, each as the live event
+// it was sent as. Content and reaction totals are as they are now, not as they were then.
+func changesSince(userID, afterID, limit int) ([]SyncEvent, error) {
+	rows, err := db.Instance.Query(`
+		SELECT c.change_id, c.conversation_type, c.message_id, c.change, c.actor_id, COALESCE(c.emoji, ''), c.created_at,
+		       a.nickname, COALESCE(m.sender_id, gm.sender_id), COALESCE(m.receiver_id, 0), COALESCE(gm.group_id, 0),
+		       COALESCE(m.content, gm.content, ''), COALESCE(m.edited_at, gm.edited_at, ''),
+		       COALESCE(m.deleted_at, gm.deleted_at) IS NOT NULL
+		`+visibleChanges+`
+		  AND c.change_id > ?
+		ORDER BY c.change_id ASC
+		LIMIT ?`, userID, userID, userID, userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []SyncEvent
+	reacted := map[string][]int{}
+	for rows.Next() {
+		var event Message
+		var kind string
+		var actorID, senderID int
+		var at time.Time
+		if err := rows.Scan(&event.ChangeID, &kind, &event.ID, &event.Type, &actorID, &event.Emoji, &at,
+			&event.SenderName, &senderID, &event.ReceiverID, &event.GroupID,
+			&event.Content, &event.EditedAt, &event.Deleted); err != nil {
+			return nil, err
+		}
+
+		switch event.Type {
+		case "message_edited":
+			// Shaped like broadcastMessageChange's events, which name the message's sender
+			event.SenderID, event.SenderName, event.Emoji = senderID, "", ""
+		case "message_deleted":
+			event.SenderID, event.SenderName, event.Emoji, event.EditedAt = senderID, "", "", ""
+			event.Deleted = true
+		default:
+			// Reaction events name the reactor and go to the other participant
+			if event.ReceiverID == actorID {
+				event.ReceiverID = senderID
+			}
+			event.SenderID, event.Content, event.EditedAt, event.Deleted = actorID, "", "", false
+			reacted[kind] = append(reacted[kind], event.ID)
+		}
+		events = append(events, SyncEvent{Kind: "change", ID: event.ChangeID, At: at, Frame: event})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for kind, ids := range reacted {
+		totals, err := loadReactions(kind, 0, ids)
+		if err != nil {
+			return nil, err
+		}
+		for i, event := range events {
+			if msg := event.Frame.(Message); msg.Emoji != "" && conversationKind(msg) == kind {
+				msg.Reactions = totals[msg.ID]
+				events[i].Frame = msg
+			}
+		}
+	}
+	return events, nil
+}
+
+// conversationKind is "group" for group messages and "user" for private ones
+func conversationKind(msg Message) string {
+	if msg.GroupID > 0 {
+		return "group"
+	}
+	return "user"
+}
@@ -0,0 +1,271 @@
+package chat
+
+import (
+	"backend/pkg/hub"
+	"backend/user"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Connection settings, overridden by Configure
+var (
+	sendBufferSize       = 256
+	writeTimeout         = 10 * time.Second
+	pingInterval         = 30 * time.Second
+	pongTimeout          = 60 * time.Second
+	maxMessageSize int64 = 32 << 10
+)
+
+// prepareConn applies the read limit and the idle deadline. The deadline is pushed back
+// by every pong and every inbound frame, so a half-open connection fails its next read
+// within pongTimeout and HandleConnections reaps it.
+func prepareConn(conn *websocket.Conn) {
+	conn.SetReadLimit(maxMessageSize)
+	extendReadDeadline(conn)
+	conn.SetPongHandler(func(string) error {
+		extendReadDeadline(conn)
+		return nil
+	})
+}
+
+func extendReadDeadline(conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+}
+
+// NewClient wraps a connection and starts its writer goroutine.
+// From here on the connection must only be written to through Send/TrySend.
+func NewClient(conn *websocket.Conn, userID int, groups []int, version int, authSession string) *Client {
+	c := &Client{
+		Conn:        conn,
+		ID:          userID,
+		Groups:      groups,
+		Session:     randomID(),
+		Version:     version,
+		AuthSession: authSession,
+		send:        make(chan []byte, sendBufferSize),
+		done:        make(chan struct{}),
+	}
+	go c.writePump()
+	return c
+}
+
+// newStreamClient is a Client without a websocket, for the event stream transport.
+// It speaks protocol version 2 and its creator drains the queue.
+func newStreamClient(userID int, groups []int) *Client {
+	return &Client{
+		ID:      userID,
+		Groups:  groups,
+		Session: randomID(),
+		Version: ProtocolVersion,
+		send:    make(chan []byte, sendBufferSize),
+		done:    make(chan struct{}),
+	}
+}
+
+// Send queues a frame that must not be lost (messages, notifications).
+// If the client is too slow to drain its queue it is disconnected rather than
+// silently missing data; it can reload history on reconnect.
+func (c *Client) Send(v Event) bool {
+	return c.enqueue(v, true)
+}
+
+// TrySend queues a frame that is fine to lose (typing indicators).
+// If the queue is full the frame is dropped and the client stays connected.
+func (c *Client) TrySend(v Event) bool {
+	return c.enqueue(v, false)
+}
+
+func (c *Client) enqueue(v Event, disconnectIfFull bool) bool {
+	frame, ok := encodeFrame(v)
+	if !ok {
+		return false
+	}
+	return c.queue(frame, disconnectIfFull)
+}
+
+// queue is enqueue for a frame that is already encoded, as frames from the hub are
+func (c *Client) queue(frame hub.Frame, disconnectIfFull bool) bool {
+	data := frameBytes(frame, c.Version)
+
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- data:
+		return true
+	default:
+		if disconnectIfFull {
+			log.Printf("User %d send queue full, disconnecting slow client", c.ID)
+			c.Close()
+		} else {
+			log.Printf("User %d send queue full, dropping frame", c.ID)
+		}
+		return false
+	}
+}
+
+// Close stops the writer goroutine, which then closes the connection.
+// The read loop in HandleConnections notices and cleans up. Safe to call more than once.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// writePump is the only goroutine that writes to the connection, pings included
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
+
+	for {
+		select {
+		case <-ticker.C:
+			if c.endIfLoggedOut() {
+				continue
+			}
+			c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("Ping failed for user %d: %v", c.ID, err)
+				c.Close()
+				return
+			}
+		case data := <-c.send:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Printf("Write error for user %d: %v", c.ID, err)
+				c.Close()
+				return
+			}
+		case <-c.done:
+			// Flush what was queued before the close (a rate limit error, say), all within one deadline
+			c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			for pending := len(c.send); pending > 0; pending-- {
+				if err := c.Conn.WriteMessage(websocket.TextMessage, <-c.send); err != nil {
+					return
+				}
+			}
+			c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+	}
+}
+
+// endIfLoggedOut closes the connection once its login session has been revoked or has expired,
+// telling the client why. Checked on every ping, so a logout reaches open connections within
+// pingInterval.
+func (c *Client) endIfLoggedOut() bool {
+	if user.IsSessionActive(c.AuthSession) {
+		return false
+	}
+	log.Printf("Session of user %d has ended, closing connection", c.ID)
+	c.Send(ErrorEvent{Type: "error", Code: ErrUnauthorized, Error: "Session expired"})
+	c.Close()
+	return true
+}
+
+// randomID names connections and frames uniquely across instances
+func randomID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// registerClient adds a connection and reports whether it is the user's first one on any instance
+func registerClient(c *Client) bool {
+	ClientsMux.Lock()
+	sessions, exists := Clients[c.ID]
+	if !exists {
+		sessions = make(map[*Client]bool)
+		Clients[c.ID] = sessions
+	}
+	sessions[c] = true
+	ClientsMux.Unlock()
+
+	first, err := realtime.Connected(c.ID)
+	if err != nil {
+		log.Printf("[Hub] Failed to record session of user %d: %v", c.ID, err)
+		return !exists
+	}
+	return first
+}
+
+// unregisterClient removes a connection and reports whether it was the user's last one on any instance
+func unregisterClient(c *Client) bool {
+	ClientsMux.Lock()
+	sessions, exists := Clients[c.ID]
+	if !exists || !sessions[c] {
+		ClientsMux.Unlock()
+		return false
+	}
+	delete(sessions, c)
+	localLast := len(sessions) == 0
+	if localLast {
+		delete(Clients, c.ID)
+	}
+	ClientsMux.Unlock()
+
+	last, err := realtime.Disconnected(c.ID)
+	if err != nil {
+		log.Printf("[Hub] Failed to drop session of user %d: %v", c.ID, err)
+		return localLast
+	}
+	return last
+}
+
+// clientsForUser copies a user's connections so frames can be queued without holding ClientsMux
+func clientsForUser(userID int) []*Client {
+	ClientsMux.Lock()
+	defer ClientsMux.Unlock()
+
+	copies := make([]*Client, 0, len(Clients[userID]))
+	for client := range Clients[userID] {
+		copies = append(copies, client)
+	}
+	return copies
+}
+
+// IsUserOnline reports whether the user has a connection on any instance
+func IsUserOnline(userID int) bool {
+	online, err := realtime.Online(userID)
+	if err != nil {
+		log.Printf("[Hub] Presence lookup failed for user %d: %v", userID, err)
+		return len(clientsForUser(userID)) > 0
+	}
+	return online
+}
+
+// SendToUser queues a frame on every connection of a user and reports whether they were online
+func SendToUser(userID int, v Event) bool {
+	return sendToUserExcept(userID, nil, v)
+}
+
+// sendToUserExcept fans a frame out to a user's connections, skipping one (usually the one it came from)
+func sendToUserExcept(userID int, except *Client, v Event) bool {
+	frame, ok := encodeFrame(v)
+	if !ok {
+		return false
+	}
+	if except != nil {
+		frame.ExceptSession = except.Session
+	}
+	publishToUser(userID, frame)
+	return IsUserOnline(userID)
+}
+
+// trySendToUser is SendToUser for frames that may be dropped (typing)
+func trySendToUser(userID int, v Event) {
+	if frame, ok := encodeFrame(v); ok {
+		frame.Droppable = true
+		publishToUser(userID, frame)
+	}
+}
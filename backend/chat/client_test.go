@@ -0,0 +1,55 @@
+package chat
+
+import "testing"
+
+func TestSlowConsumer(t *testing.T) {
+	defer func(size int) { sendBufferSize = size }(sendBufferSize)
+	sendBufferSize = 2
+
+	t.Run("droppable frames are dropped", func(t *testing.T) {
+		c := newStreamClient(1, nil)
+		for i := 0; i < sendBufferSize; i++ {
+			if !c.TrySend(Ack{Type: "ack", ID: i}) {
+				t.Fatalf("frame %d refused before the queue was full", i)
+			}
+		}
+		if c.TrySend(Ack{Type: "ack"}) {
+			t.Error("frame queued past the buffer")
+		}
+		if closed(c) {
+			t.Error("client closed over a droppable frame")
+		}
+		// Once there is room again, frames go through
+		<-c.send
+		if !c.TrySend(Ack{Type: "ack"}) {
+			t.Error("frame refused after the queue drained")
+		}
+	})
+
+	t.Run("frames that must arrive disconnect", func(t *testing.T) {
+		c := newStreamClient(1, nil)
+		for i := 0; i < sendBufferSize; i++ {
+			c.Send(Ack{Type: "ack", ID: i})
+		}
+		if c.Send(Ack{Type: "ack"}) {
+			t.Error("frame queued past the buffer")
+		}
+		if !closed(c) {
+			t.Fatal("slow client left connected")
+		}
+		// A closed client takes nothing more, even with room in the queue
+		<-c.send
+		if c.TrySend(Ack{Type: "ack"}) || c.Send(Ack{Type: "ack"}) {
+			t.Error("closed client still queues frames")
+		}
+	})
+}
+
+func closed(c *Client) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,340 @@
+package chat
+
+import (
+	"backend/db"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// editWindow is how long after sending a sender may edit or delete a message; 0 means no limit.
+// Group admins may delete any message in their group at any time.
+var editWindow = 15 * time.Minute
+
+var (
+	errMessageNotFound  = errors.New("message not found")
+	errMessageDeleted   = errors.New("message was deleted")
+	errNotAllowed       = errors.New("not allowed to change this message")
+	errEditWindowClosed = errors.New("edit window has passed")
+)
+
+// changeErrors is what the client is told for each refusal
+var changeErrors = map[error]struct{ code, text string }{
+	errMessageNotFound:  {ErrNotFound, "Message not found"},
+	errMessageDeleted:   {ErrNotFound, "Message was deleted"},
+	errNotAllowed:       {ErrForbidden, "You cannot change this message"},
+	errEditWindowClosed: {ErrForbidden, "Messages can only be changed shortly after sending"},
+}
+
+// storedMessage is what an edit or delete is checked against
+type storedMessage struct {
+	ID         int
+	SenderID   int
+	ReceiverID int
+	GroupID    int
+	CreatedAt  time.Time
+	Deleted    bool
+}
+
+// messageTable maps a conversation type to its table; never built from client input
+func messageTable(kind string) string {
+	if kind == "group" {
+		return "group_messages"
+	}
+	return "messages"
+}
+
+func loadStoredMessage(kind string, id int) (storedMessage, error) {
+	var m storedMessage
+	var err error
+	if kind == "group" {
+		err = db.Instance.QueryRow(`
+			SELECT message_id, sender_id, group_id, created_at, deleted_at IS NOT NULL
+			FROM group_messages WHERE message_id = ?`, id).
+			Scan(&m.ID, &m.SenderID, &m.GroupID, &m.CreatedAt, &m.Deleted)
+	} else {
+		err = db.Instance.QueryRow(`
+			SELECT message_id, sender_id, receiver_id, created_at, deleted_at IS NOT NULL
+			FROM messages WHERE message_id = ?`, id).
+			Scan(&m.ID, &m.SenderID, &m.ReceiverID, &m.CreatedAt, &m.Deleted)
+	}
+	if err == sql.ErrNoRows {
+		return m, errMessageNotFound
+	}
+	return m, err
+}
+
+// IsGroupAdmin reports whether a user is the creator or an admin of a group
+func IsGroupAdmin(userID, groupID int) bool {
+	var count int
+	db.Instance.QueryRow(`
+		SELECT COUNT(*) FROM group_memberships
+		WHERE user_id = ? AND group_id = ? AND status = 'accepted' AND role IN ('creator','admin')
+	`, userID, groupID).Scan(&count)
+	return count > 0
+}
+
+// canChange enforces who may edit or delete a message and when
+func canChange(m storedMessage, actorID int, deleting bool) error {
+	if m.Deleted {
+		return errMessageDeleted
+	}
+
+	if actorID == m.SenderID {
+		if m.GroupID > 0 && !IsUserInGroup(actorID, m.GroupID) {
+			return errNotAllowed
+		}
+		if editWindow > 0 && time.Since(m.CreatedAt) > editWindow {
+			return errEditWindowClosed
+		}
+		return nil
+	}
+
+	// Moderation: admins can remove, but never rewrite, other members' messages
+	if deleting && m.GroupID > 0 && IsGroupAdmin(actorID, m.GroupID) {
+		return nil
+	}
+	return errNotAllowed
+}
+
+// HandleEdit processes {"type":"edit","id":N,"content":"..."}; group_id selects a group message
+func HandleEdit(sender *Client, msg Message) {
+	content := strings.TrimSpace(msg.Content)
+	if msg.ID <= 0 || content == "" {
+		sendError(sender, msg, ErrInvalidRequest, "Edit requires a message id and new content")
+		return
+	}
+
+	kind, stored, err := authorizeChange(msg, false)
+	if err != nil {
+		sendChangeError(sender, msg, err)
+		return
+	}
+
+	editedAt := time.Now().Format(time.RFC3339)
+	changeID, err := editMessage(kind, stored.ID, msg.SenderID, content, editedAt)
+	if err != nil {
+		log.Printf("Failed to edit message %d: %v", stored.ID, err)
+		sendChangeError(sender, msg, err)
+		return
+	}
+
+	broadcastMessageChange(stored, Message{
+		Type:     "message_edited",
+		ID:       stored.ID,
+		Content:  content,
+		EditedAt: editedAt,
+		ChangeID: changeID,
+	})
+}
+
+// HandleDelete processes {"type":"delete","id":N}; group_id selects a group message
+func HandleDelete(sender *Client, msg Message) {
+	if msg.ID <= 0 {
+		sendError(sender, msg, ErrInvalidRequest, "Delete requires a message id")
+		return
+	}
+
+	kind, stored, err := authorizeChange(msg, true)
+	if err != nil {
+		sendChangeError(sender, msg, err)
+		return
+	}
+
+	changeID, err := deleteMessage(kind, stored.ID, msg.SenderID, time.Now().Format(time.RFC3339))
+	if err != nil {
+		log.Printf("Failed to delete message %d: %v", stored.ID, err)
+		sendChangeError(sender, msg, err)
+		return
+	}
+
+	broadcastMessageChange(stored, Message{
+		Type:     "message_deleted",
+		ID:       stored.ID,
+		Deleted:  true,
+		ChangeID: changeID,
+	})
+}
+
+func authorizeChange(msg Message, deleting bool) (string, storedMessage, error) {
+	kind := "user"
+	if msg.GroupID > 0 {
+		kind = "group"
+	}
+
+	stored, err := loadStoredMessage(kind, msg.ID)
+	if err != nil {
+		return kind, stored, err
+	}
+	return kind, stored, canChange(stored, msg.SenderID, deleting)
+}
+
+func sendChangeError(sender *Client, msg Message, err error) {
+	refusal, known := changeErrors[err]
+	if !known {
+		refusal.code, refusal.text = ErrInternal, "Failed to update message"
+	}
+	sendError(sender, msg, refusal.code, refusal.text)
+}
+
+// editMessage keeps the replaced content in message_edits and updates the row, returning the change_id
+func editMessage(kind string, id, editorID int, content, editedAt string) (int, error) {
+	tx, err := db.Instance.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	table := messageTable(kind)
+	if _, err := tx.Exec(fmt.Sprintf(`
+		INSERT INTO message_edits (conversation_type, message_id, editor_id, previous_content, edited_at)
+		SELECT ?, message_id, ?, content, ? FROM %s WHERE message_id = ?`, table),
+		kind, editorID, editedAt, id); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET content = ?, edited_at = ? WHERE message_id = ? AND deleted_at IS NULL`, table),
+		content, editedAt, id)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, errMessageDeleted
+	}
+	changeID, err := recordChange(tx, kind, id, "message_edited", editorID, "")
+	if err != nil {
+		return 0, err
+	}
+	return changeID, tx.Commit()
+}
+
+// deleteMessage turns the row into a tombstone and drops its edit history, reactions and
+// attachment, so the retracted content is gone rather than hidden. Returns the change_id.
+func deleteMessage(kind string, id, actorID int, deletedAt string) (int, error) {
+	tx, err := db.Instance.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET content = '', media = NULL, deleted_at = ? WHERE message_id = ? AND deleted_at IS NULL`,
+		messageTable(kind)), deletedAt, id)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, errMessageDeleted
+	}
+	if _, err := tx.Exec(`DELETE FROM message_edits WHERE conversation_type = ? AND message_id = ?`, kind, id); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM message_reactions WHERE conversation_type = ? AND message_id = ?`, kind, id); err != nil {
+		return 0, err
+	}
+	filePath, err := releaseAttachment(tx, kind, id)
+	if err != nil {
+		return 0, err
+	}
+	changeID, err := recordChange(tx, kind, id, "message_deleted", actorID, "")
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	if filePath != "" {
+		if err := os.Remove(filePath); err != nil {
+			log.Printf("Failed to remove attachment %s of deleted message %d: %v", filePath, id, err)
+		}
+	}
+	return changeID, nil
+}
+
+// broadcastMessageChange tells everyone in the conversation, the actor's own sessions included.
+// The receiver of a private message only hears of it once the sender can reach them, so
+// rewriting a message request that is pending or refused doesn't get through.
+func broadcastMessageChange(stored storedMessage, event Message) {
+	event.SenderID = stored.SenderID
+	if stored.GroupID > 0 {
+		event.GroupID = stored.GroupID
+		broadcastToGroup(stored.GroupID, 0, event)
+		return
+	}
+
+	event.ReceiverID = stored.ReceiverID
+	if canReach(stored.SenderID, stored.ReceiverID) {
+		ForwardPrivateMessage(event)
+	}
+	SendToUser(stored.SenderID, event)
+}
+
+// GetMessageEditsHandler returns the edit history of a message, oldest first:
+// GET /chat/message-edits?conversation_type=user|group&message_id=N
+func GetMessageEditsHandler(w http.ResponseWriter, r *http.Request) {
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	kind := r.URL.Query().Get("conversation_type")
+	if kind != "user" && kind != "group" {
+		http.Error(w, "conversation_type must be user or group", http.StatusBadRequest)
+		return
+	}
+	messageID := queryInt(r.URL.Query().Get("message_id"))
+
+	stored, err := loadStoredMessage(kind, messageID)
+	if err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if kind == "group" && !IsUserInGroup(userID, stored.GroupID) ||
+		kind == "user" && userID != stored.SenderID && userID != stored.ReceiverID {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := db.Instance.Query(`
+		SELECT e.edit_id, e.editor_id, COALESCE(e.previous_content, ''), e.edited_at
+		FROM message_edits e
+		WHERE e.conversation_type = ? AND e.message_id = ?
+		ORDER BY e.edit_id ASC`, kind, messageID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type MessageEdit struct {
+		EditID          int    `json:"edit_id"`
+		EditorID        int    `json:"editor_id"`
+		PreviousContent string `json:"previous_content"`
+		EditedAt        string `json:"edited_at"`
+	}
+
+	edits := []MessageEdit{}
+	for rows.Next() {
+		var edit MessageEdit
+		var editedAt time.Time
+		if err := rows.Scan(&edit.EditID, &edit.EditorID, &edit.PreviousContent, &editedAt); err == nil {
+			edit.EditedAt = editedAt.Format(time.RFC3339)
+			edits = append(edits, edit)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(edits)
+}
@@ -0,0 +1,101 @@
+package chat
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Joiners and modifiers that may appear inside an emoji, but not on their own
+const (
+	zeroWidthJoiner   = '\u200d'
+	textPresentation  = '\ufe0e'
+	emojiPresentation = '\ufe0f'
+	combiningKeycap   = '\u20e3'
+	cancelTag         = '\U000e007f'
+)
+
+// emojiBases are the code points that are emoji by themselves: the pictographic blocks, plus
+// the older symbols that gained an emoji presentation
+var emojiBases = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x00a9, 0x00ae, 5},
+		{0x203c, 0x2049, 13},
+		{0x2122, 0x2139, 23},
+		{0x2194, 0x2199, 1},
+		{0x21a9, 0x21aa, 1},
+		{0x231a, 0x231b, 1},
+		{0x2328, 0x23cf, 167},
+		{0x23e9, 0x23f3, 1},
+		{0x23f8, 0x23fa, 1},
+		{0x24c2, 0x25aa, 232},
+		{0x25ab, 0x25b6, 11},
+		{0x25c0, 0x25fb, 59},
+		{0x25fc, 0x25fe, 1},
+		{0x2600, 0x27bf, 1},
+		{0x2934, 0x2935, 1},
+		{0x2b05, 0x2b07, 1},
+		{0x2b1b, 0x2b1c, 1},
+		{0x2b50, 0x2b55, 5},
+		{0x3030, 0x303d, 13},
+		{0x3297, 0x3299, 2},
+	},
+	R32: []unicode.Range32{
+		{0x1f000, 0x1faff, 1},
+	},
+	LatinOffset: 1,
+}
+
+// isEmoji reports whether s is one or more emoji joined by zero-width joiners, each optionally
+// followed by a presentation selector and a skin tone. Flags, keycaps and subdivision flags
+// (a black flag followed by tag characters) are accepted too; anything else, such as text, is not.
+func isEmoji(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, part := range strings.Split(s, string(zeroWidthJoiner)) {
+		if !isEmojiElement([]rune(part)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEmojiElement(r []rune) bool {
+	if len(r) == 0 {
+		return false
+	}
+	if len(r) == 2 && isRegionalIndicator(r[0]) && isRegionalIndicator(r[1]) {
+		return true
+	}
+
+	rest := r[1:]
+	if strings.ContainsRune("0123456789#*", r[0]) {
+		if len(rest) > 0 && rest[0] == emojiPresentation {
+			rest = rest[1:]
+		}
+		return len(rest) == 1 && rest[0] == combiningKeycap
+	}
+	if !unicode.Is(emojiBases, r[0]) {
+		return false
+	}
+
+	if len(rest) > 0 && (rest[0] == emojiPresentation || rest[0] == textPresentation) {
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && isSkinTone(rest[0]) {
+		rest = rest[1:]
+	}
+	if len(rest) > 1 && rest[len(rest)-1] == cancelTag {
+		for _, tag := range rest[:len(rest)-1] {
+			if tag < 0xe0020 || tag > 0xe007e {
+				return false
+			}
+		}
+		return true
+	}
+	return len(rest) == 0
+}
+
+func isRegionalIndicator(r rune) bool { return r >= 0x1f1e6 && r <= 0x1f1ff }
+
+func isSkinTone(r rune) bool { return r >= 0x1f3fb && r <= 0x1f3ff }
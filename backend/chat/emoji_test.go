@@ -0,0 +1,36 @@
+package chat
+
+import "testing"
+
+func TestIsEmoji(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"👍", true},
+		{"❤\ufe0f", true},                // heart with emoji presentation
+		{"👍🏽", true},                     // skin tone
+		{"👩\u200d💻", true},               // ZWJ sequence
+		{"👨\u200d👩\u200d👧\u200d👦", true}, // family
+		{"🏳\ufe0f\u200d🌈", true},         // ZWJ with a presentation selector inside
+		{"🇫🇷", true},                     // flag
+		{"1\ufe0f\u20e3", true},          // keycap
+		{"🏴\U000e0067\U000e0062\U000e0073\U000e0063\U000e0074\U000e007f", true}, // subdivision flag
+		{"👍👍", false}, // one reaction is one emoji
+		{"", false},
+		{"a", false},
+		{"1", false}, // a keycap base alone
+		{"lol", false},
+		{"👍a", false},
+		{"\u200d👍", false}, // dangling joiner
+		{"👍\u200d", false},
+		{"\ufe0f", false}, // selector alone
+		{"<script>", false},
+		{"👍 👍", false},
+	}
+	for _, tt := range tests {
+		if got := isEmoji(tt.in); got != tt.want {
+			t.Errorf("isEmoji(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,700 @@
+package chat
+
+import (
+	"backend/db"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Group DM limits; maxGroupDMSize is overridden by Configure
+var maxGroupDMSize = 32
+
+const maxGroupDMTitleLength = 100
+
+// Group DM changes besides MemberJoined, MemberLeft and MemberRemoved
+const (
+	DMCreated = "created"
+	DMRenamed = "renamed"
+)
+
+var (
+	errNotInGroupDM     = errors.New("not in this conversation")
+	errCannotAddToDM    = errors.New("cannot add that user")
+	errAlreadyInGroupDM = errors.New("already in this conversation")
+	errGroupDMFull      = errors.New("conversation is full")
+)
+
+// GroupDM is an ad-hoc conversation among three or more users, outside any group. Anyone in it
+// may add people they can message freely; only its creator may remove them. Messages are text
+// only, and people added later don't see what was said before they joined. They can't be
+// edited, deleted, reacted to or replied to, and take a read marker but no per-message receipts.
+type GroupDM struct {
+	ID           int             `json:"dm_id"`
+	Title        string          `json:"title"` // empty until someone names it
+	CreatorID    int             `json:"creator_id"`
+	CreatedAt    string          `json:"created_at"`
+	Participants []DMParticipant `json:"participants"`
+}
+
+type DMParticipant struct {
+	UserID   int    `json:"user_id"`
+	Nickname string `json:"nickname"`
+	AddedBy  int    `json:"added_by,omitempty"`
+	JoinedAt string `json:"joined_at"`
+}
+
+// GroupDMChanged tells a group DM's participants, and whoever just left it, what changed
+type GroupDMChanged struct {
+	Type     string   `json:"type"` // "group_dm_changed"
+	DMID     int      `json:"dm_id"`
+	Change   string   `json:"change"`               // "created", "renamed", or one of MemberJoined, MemberLeft, MemberRemoved
+	UserID   int      `json:"user_id,omitempty"`    // the participant who joined, left or was removed
+	ByUserID int      `json:"by_user_id,omitempty"` // who made the change, when not the participant themselves
+	DM       *GroupDM `json:"dm,omitempty"`         // the conversation after the change; not sent to whoever left it
+}
+
+func (g GroupDMChanged) EventType() string { return g.Type }
+
+func isDMParticipant(userID, dmID int) bool {
+	var exists int
+	err := db.Instance.QueryRow("SELECT 1 FROM group_dm_participants WHERE dm_id = ? AND user_id = ?",
+		dmID, userID).Scan(&exists)
+	return err == nil && exists == 1
+}
+
+func dmParticipantIDs(dmID int) ([]int, error) {
+	rows, err := db.Instance.Query("SELECT user_id FROM group_dm_participants WHERE dm_id = ?", dmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// loadGroupDM reads a conversation with its participants, in the order they joined
+func loadGroupDM(dmID int) (*GroupDM, error) {
+	dm := &GroupDM{ID: dmID, Participants: []DMParticipant{}}
+	var createdAt time.Time
+	if err := db.Instance.QueryRow("SELECT title, creator_id, created_at FROM group_dms WHERE dm_id = ?", dmID).
+		Scan(&dm.Title, &dm.CreatorID, &createdAt); err != nil {
+		return nil, err
+	}
+	dm.CreatedAt = createdAt.Format(time.RFC3339)
+
+	rows, err := db.Instance.Query(`
+		SELECT p.user_id, u.nickname, COALESCE(p.added_by, 0), p.joined_at
+		FROM group_dm_participants p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.dm_id = ?
+		ORDER BY p.joined_at ASC, p.user_id ASC`, dmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var participant DMParticipant
+		var joinedAt time.Time
+		if err := rows.Scan(&participant.UserID, &participant.Nickname, &participant.AddedBy, &joinedAt); err != nil {
+			return nil, err
+		}
+		participant.JoinedAt = joinedAt.Format(time.RFC3339)
+		dm.Participants = append(dm.Participants, participant)
+	}
+	return dm, rows.Err()
+}
+
+// canAddToGroupDM reports whether adderID may bring userID into a conversation: only people
+// they could message freely, so a group DM is no way around message requests
+func canAddToGroupDM(adderID, userID int) error {
+	if _, err := GetUserName(userID); err != nil {
+		return errCannotAddToDM
+	}
+	access, err := privateMessageAccess(adderID, userID)
+	if err != nil {
+		return err
+	}
+	if access != accessAllowed {
+		return errCannotAddToDM
+	}
+	return nil
+}
+
+// addDMParticipant adds a user to a conversation; they see the messages sent from now on
+func addDMParticipant(tx *sql.Tx, dmID, userID, addedBy int, now string) error {
+	var addedByID interface{}
+	if addedBy != userID {
+		addedByID = addedBy
+	}
+	_, err := tx.Exec(`
+		INSERT INTO group_dm_participants (dm_id, user_id, added_by, joined_at, visible_after)
+		VALUES (?, ?, ?, ?, (SELECT COALESCE(MAX(message_id), 0) FROM group_dm_messages WHERE dm_id = ?))`,
+		dmID, userID, addedByID, now, dmID)
+	return err
+}
+
+// joinGroupDM adds a user to an existing conversation on behalf of one of its participants.
+// The adder's membership and the size limit are checked by the insert itself, so concurrent
+// adds can't overfill it, and one that finds the user already there changes nothing.
+func joinGroupDM(dmID, userID, addedBy int, now string) error {
+	result, err := db.Instance.Exec(`
+		INSERT INTO group_dm_participants (dm_id, user_id, added_by, joined_at, visible_after)
+		SELECT ?, ?, ?, ?, (SELECT COALESCE(MAX(message_id), 0) FROM group_dm_messages WHERE dm_id = ?)
+		WHERE EXISTS (SELECT 1 FROM group_dm_participants WHERE dm_id = ? AND user_id = ?)
+		  AND (SELECT COUNT(*) FROM group_dm_participants WHERE dm_id = ?) < ?
+		ON CONFLICT (dm_id, user_id) DO NOTHING`,
+		dmID, userID, addedBy, now, dmID, dmID, addedBy, dmID, maxGroupDMSize)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 1 {
+		return err
+	}
+
+	// Nothing was inserted; work out why
+	switch {
+	case isDMParticipant(userID, dmID):
+		return errAlreadyInGroupDM
+	case !isDMParticipant(addedBy, dmID):
+		return errNotInGroupDM
+	default:
+		return errGroupDMFull
+	}
+}
+
+// publishGroupDMChange sends the conversation as it now stands to everyone in it, and tells
+// formerID, who is no longer in it, without the details
+func publishGroupDMChange(change GroupDMChanged, formerID int) {
+	change.Type = "group_dm_changed"
+	if formerID > 0 {
+		SendToUser(formerID, change)
+	}
+
+	dm, err := loadGroupDM(change.DMID)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("[GroupDM] Error loading conversation %d: %v", change.DMID, err)
+		return
+	}
+	change.DM = dm
+	for _, participant := range dm.Participants {
+		SendToUser(participant.UserID, change)
+	}
+	log.Printf("[GroupDM] Conversation %d: %s (user %d)", change.DMID, change.Change, change.UserID)
+}
+
+// notForGroupDMs are the frame types refused when they carry dm_id. Group DM message ids are
+// their own id space, and these handlers pick a table from group_id alone, so acting on them
+// would touch a private or group message that happens to share the id.
+var notForGroupDMs = map[string]bool{
+	"private": true, "group": true, "edit": true, "delete": true, "react": true, "unreact": true, "delivered": true,
+}
+
+// HandleGroupDMMessage saves a message to a group DM and sends it to every participant
+func HandleGroupDMMessage(sender *Client, msg Message) {
+	if !isDMParticipant(msg.SenderID, msg.DMID) {
+		sendError(sender, msg, ErrForbidden, "You are not in this conversation")
+		return
+	}
+	if msg.ReplyToID > 0 || msg.AttachmentID > 0 {
+		sendError(sender, msg, ErrInvalidRequest, "Group DMs only take text")
+		return
+	}
+	if strings.TrimSpace(msg.Content) == "" {
+		sendError(sender, msg, ErrInvalidRequest, "Message is empty")
+		return
+	}
+
+	result, err := db.Instance.Exec("INSERT INTO group_dm_messages (dm_id, sender_id, content, created_at) VALUES (?, ?, ?, ?)",
+		msg.DMID, msg.SenderID, msg.Content, msg.SentAt)
+	if err != nil {
+		log.Printf("Failed to save group DM message: %v", err)
+		sendError(sender, msg, ErrInternal, "Failed to send message")
+		return
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		sendError(sender, msg, ErrInternal, "Failed to send message")
+		return
+	}
+	msg.ID = int(id)
+	sender.Send(Ack{Type: "ack", ID: msg.ID, Nonce: msg.Nonce, SentAt: msg.SentAt})
+	msg.Nonce = ""
+
+	participants, err := dmParticipantIDs(msg.DMID)
+	if err != nil {
+		log.Printf("[GroupDM] Error loading participants of conversation %d: %v", msg.DMID, err)
+	}
+	for _, participantID := range participants {
+		if participantID == msg.SenderID {
+			continue
+		}
+		SendToUser(participantID, msg)
+		pushUnread(participantID, "group_dm", msg.DMID)
+	}
+
+	// Keep the sender's other tabs/devices in sync
+	sendToUserExcept(msg.SenderID, sender, msg)
+}
+
+// BroadcastTypingToGroupDM tells the other participants that the sender is typing
+func BroadcastTypingToGroupDM(msg Message) {
+	participants, err := dmParticipantIDs(msg.DMID)
+	if err != nil || !slices.Contains(participants, msg.SenderID) {
+		return
+	}
+	for _, participantID := range participants {
+		if participantID != msg.SenderID {
+			trySendToUser(participantID, msg)
+		}
+	}
+}
+
+// queryGroupDMHistory reads one page of a conversation as the user may see it, in the page's scan order
+func queryGroupDMHistory(userID, dmID int, p historyPage) ([]Message, error) {
+	pageClause, pageArgs := p.clause("m.message_id")
+	rows, err := db.Instance.Query(`
+		SELECT m.message_id, m.sender_id, u.nickname, m.content, m.created_at
+		FROM group_dm_messages m
+		JOIN users u ON u.id = m.sender_id
+		JOIN group_dm_participants p ON p.dm_id = m.dm_id AND p.user_id = ?
+		WHERE m.dm_id = ? AND m.message_id > p.visible_after`+pageClause,
+		append([]interface{}{userID, dmID}, pageArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		msg := Message{Type: "group_dm", DMID: dmID}
+		var createdAt time.Time
+		if err := rows.Scan(&msg.ID, &msg.SenderID, &msg.SenderName, &msg.Content, &createdAt); err != nil {
+			return nil, err
+		}
+		msg.SentAt = createdAt.Format(time.RFC3339)
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// groupDMMessagesSince is groupMessagesSince for group DMs, the user's own messages included
+func groupDMMessagesSince(userID, afterID, limit int) ([]SyncEvent, error) {
+	rows, err := db.Instance.Query(`
+		SELECT m.message_id, m.dm_id, m.sender_id, u.nickname, m.content, m.created_at
+		FROM group_dm_messages m
+		JOIN users u ON u.id = m.sender_id
+		JOIN group_dm_participants p ON p.dm_id = m.dm_id AND p.user_id = ?
+		WHERE m.message_id > ? AND m.message_id > p.visible_after
+		ORDER BY m.message_id ASC
+		LIMIT ?`, userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []SyncEvent
+	for rows.Next() {
+		msg := Message{Type: "group_dm"}
+		var createdAt time.Time
+		if err := rows.Scan(&msg.ID, &msg.DMID, &msg.SenderID, &msg.SenderName, &msg.Content, &createdAt); err != nil {
+			return nil, err
+		}
+		msg.SentAt = createdAt.Format(time.RFC3339)
+		events = append(events, SyncEvent{Kind: "group_dm", ID: msg.ID, At: createdAt, Frame: msg})
+	}
+	return events, rows.Err()
+}
+
+// groupDMUser resolves the authenticated user of a group DM request
+func groupDMUser(w http.ResponseWriter, r *http.Request, method string) (int, bool) {
+	if r.Method != method {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return 0, false
+	}
+
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return 0, false
+	}
+	return userID, true
+}
+
+func writeGroupDM(w http.ResponseWriter, dmID int) {
+	dm, err := loadGroupDM(dmID)
+	if err != nil {
+		log.Printf("[GroupDM] Error loading conversation %d: %v", dmID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dm)
+}
+
+// cleanTitle trims a title and checks its length
+func cleanTitle(title string) (string, bool) {
+	title = strings.TrimSpace(title)
+	return title, utf8.RuneCountInString(title) <= maxGroupDMTitleLength
+}
+
+// CreateGroupDMHandler starts a conversation: POST /chat/dms/create {title, user_ids}.
+// user_ids are the others in it, at least two, all people the caller may message freely.
+func CreateGroupDMHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := groupDMUser(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Title   string `json:"title"`
+		UserIDs []int  `json:"user_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	title, ok := cleanTitle(req.Title)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Title is longer than %d characters", maxGroupDMTitleLength), http.StatusBadRequest)
+		return
+	}
+
+	var others []int
+	for _, id := range req.UserIDs {
+		if id != userID && !slices.Contains(others, id) {
+			others = append(others, id)
+		}
+	}
+	if len(others) < 2 || len(others)+1 > maxGroupDMSize {
+		http.Error(w, fmt.Sprintf("A group DM has 3 to %d participants", maxGroupDMSize), http.StatusBadRequest)
+		return
+	}
+	for _, id := range others {
+		if err := canAddToGroupDM(userID, id); err != nil {
+			if err == errCannotAddToDM {
+				http.Error(w, fmt.Sprintf("You cannot add user %d", id), http.StatusForbidden)
+				return
+			}
+			log.Printf("Error checking message permissions: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	tx, err := db.Instance.Begin()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO group_dms (title, creator_id, created_at) VALUES (?, ?, ?)", title, userID, now)
+	if err != nil {
+		log.Printf("[GroupDM] Error creating conversation: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	dmID := int(id)
+	for _, participantID := range append([]int{userID}, others...) {
+		if err := addDMParticipant(tx, dmID, participantID, userID, now); err != nil {
+			log.Printf("[GroupDM] Error adding user %d to conversation %d: %v", participantID, dmID, err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	publishGroupDMChange(GroupDMChanged{DMID: dmID, Change: DMCreated, UserID: userID}, 0)
+	writeGroupDM(w, dmID)
+}
+
+// GroupDMHandler returns a conversation with its participants: GET /chat/dms?dm_id=
+func GroupDMHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := groupDMUser(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+
+	dmID := queryInt(r.URL.Query().Get("dm_id"))
+	if !isDMParticipant(userID, dmID) {
+		http.Error(w, "You are not in this conversation", http.StatusForbidden)
+		return
+	}
+	writeGroupDM(w, dmID)
+}
+
+// GroupDMMessagesHandler pages through a conversation: GET /chat/dms/messages?dm_id=, with the
+// paging parameters of the other history endpoints
+func GroupDMMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := groupDMUser(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+
+	dmID := queryInt(r.URL.Query().Get("dm_id"))
+	if !isDMParticipant(userID, dmID) {
+		http.Error(w, "You are not in this conversation", http.StatusForbidden)
+		return
+	}
+
+	page := parseHistoryPage(r.URL.Query())
+	messages, more, err := loadHistory(page, func(p historyPage) ([]Message, error) {
+		return queryGroupDMHistory(userID, dmID, p)
+	})
+	if err != nil {
+		log.Printf("[GroupDM] Error loading messages of conversation %d: %v", dmID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	writePage(w, page, messages, more)
+}
+
+// AddToGroupDMHandler adds someone to a conversation: POST /chat/dms/add {dm_id, user_id}
+func AddToGroupDMHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := groupDMUser(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		DMID   int `json:"dm_id"`
+		UserID int `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if !isDMParticipant(userID, req.DMID) {
+		http.Error(w, "You are not in this conversation", http.StatusForbidden)
+		return
+	}
+	if err := canAddToGroupDM(userID, req.UserID); err != nil {
+		if err == errCannotAddToDM {
+			http.Error(w, "You cannot add this user", http.StatusForbidden)
+			return
+		}
+		log.Printf("Error checking message permissions: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := joinGroupDM(req.DMID, req.UserID, userID, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		switch err {
+		case errNotInGroupDM:
+			http.Error(w, "You are not in this conversation", http.StatusForbidden)
+		case errAlreadyInGroupDM:
+			http.Error(w, "User is already in this conversation", http.StatusConflict)
+		case errGroupDMFull:
+			http.Error(w, fmt.Sprintf("A group DM has at most %d participants", maxGroupDMSize), http.StatusConflict)
+		default:
+			log.Printf("[GroupDM] Error adding user %d to conversation %d: %v", req.UserID, req.DMID, err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	publishGroupDMChange(GroupDMChanged{DMID: req.DMID, Change: MemberJoined, UserID: req.UserID, ByUserID: userID}, 0)
+	writeGroupDM(w, req.DMID)
+}
+
+// RemoveFromGroupDMHandler takes someone out of a conversation; only its creator may:
+// POST /chat/dms/remove {dm_id, user_id}
+func RemoveFromGroupDMHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := groupDMUser(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		DMID   int `json:"dm_id"`
+		UserID int `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var creatorID int
+	err := db.Instance.QueryRow("SELECT creator_id FROM group_dms WHERE dm_id = ?", req.DMID).Scan(&creatorID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[GroupDM] Error loading conversation %d: %v", req.DMID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !isDMParticipant(userID, req.DMID) {
+		http.Error(w, "You are not in this conversation", http.StatusForbidden)
+		return
+	}
+	if creatorID != userID {
+		http.Error(w, "Only the creator of the conversation can remove people", http.StatusForbidden)
+		return
+	}
+	if req.UserID == userID {
+		http.Error(w, "Leave the conversation instead", http.StatusBadRequest)
+		return
+	}
+
+	if err := leaveGroupDM(req.DMID, req.UserID); err != nil {
+		if err == errNotInGroupDM {
+			http.Error(w, "User is not in this conversation", http.StatusNotFound)
+			return
+		}
+		log.Printf("[GroupDM] Error removing user %d from conversation %d: %v", req.UserID, req.DMID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	publishGroupDMChange(GroupDMChanged{DMID: req.DMID, Change: MemberRemoved, UserID: req.UserID, ByUserID: userID}, req.UserID)
+	writeGroupDM(w, req.DMID)
+}
+
+// LeaveGroupDMHandler takes the caller out of a conversation: POST /chat/dms/leave {dm_id}
+func LeaveGroupDMHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := groupDMUser(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		DMID int `json:"dm_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := leaveGroupDM(req.DMID, userID); err != nil {
+		if err == errNotInGroupDM {
+			http.Error(w, "You are not in this conversation", http.StatusForbidden)
+			return
+		}
+		log.Printf("[GroupDM] Error leaving conversation %d for user %d: %v", req.DMID, userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	publishGroupDMChange(GroupDMChanged{DMID: req.DMID, Change: MemberLeft, UserID: userID}, userID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"dm_id": req.DMID, "left": true})
+}
+
+// leaveGroupDM takes a participant out. A departing creator hands over to whoever has been in
+// the longest, and the last one out deletes the conversation.
+func leaveGroupDM(dmID, userID int) error {
+	tx, err := db.Instance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM group_dm_participants WHERE dm_id = ? AND user_id = ?", dmID, userID)
+	if err != nil {
+		return err
+	}
+	if left, err := result.RowsAffected(); err != nil || left == 0 {
+		return errNotInGroupDM
+	}
+
+	var successor int
+	err = tx.QueryRow(`
+		SELECT user_id FROM group_dm_participants WHERE dm_id = ?
+		ORDER BY joined_at ASC, user_id ASC LIMIT 1`, dmID).Scan(&successor)
+	switch {
+	case err == sql.ErrNoRows:
+		if _, err := tx.Exec("DELETE FROM group_dm_messages WHERE dm_id = ?", dmID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM conversation_settings WHERE conversation_type = 'group_dm' AND conversation_id = ?", dmID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM group_dms WHERE dm_id = ?", dmID); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		if _, err := tx.Exec("UPDATE group_dms SET creator_id = ? WHERE dm_id = ? AND creator_id = ?", successor, dmID, userID); err != nil {
+			return err
+		}
+	}
+	// Their settings for it go with them
+	if _, err := tx.Exec("DELETE FROM conversation_settings WHERE user_id = ? AND conversation_type = 'group_dm' AND conversation_id = ?",
+		userID, dmID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RenameGroupDMHandler sets a conversation's title; anyone in it may: POST /chat/dms/rename {dm_id, title}.
+// An empty title goes back to naming it after the participants.
+func RenameGroupDMHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := groupDMUser(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		DMID  int    `json:"dm_id"`
+		Title string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if !isDMParticipant(userID, req.DMID) {
+		http.Error(w, "You are not in this conversation", http.StatusForbidden)
+		return
+	}
+	title, ok := cleanTitle(req.Title)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Title is longer than %d characters", maxGroupDMTitleLength), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.Instance.Exec("UPDATE group_dms SET title = ? WHERE dm_id = ?", title, req.DMID); err != nil {
+		log.Printf("[GroupDM] Error renaming conversation %d: %v", req.DMID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	publishGroupDMChange(GroupDMChanged{DMID: req.DMID, Change: DMRenamed, ByUserID: userID}, 0)
+	writeGroupDM(w, req.DMID)
+}
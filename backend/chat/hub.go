@@ -0,0 +1,58 @@
+package chat
+
+import (
+	"backend/pkg/hub"
+	"log"
+)
+
+// realtime carries frames and presence to every instance; the default serves a single one
+var realtime hub.Hub = hub.NewMemory(Local)
+
+// Local delivers hub frames to the connections on this instance
+var Local hub.Local = localDelivery{}
+
+// UseHub switches to a hub shared with other instances, built by main from the config
+func UseHub(h hub.Hub) {
+	realtime = h
+}
+
+func publishToUser(userID int, frame hub.Frame) {
+	if err := realtime.PublishToUser(userID, frame); err != nil {
+		log.Printf("[Hub] Failed to publish to user %d: %v", userID, err)
+	}
+}
+
+func publishToGroup(groupID int, frame hub.Frame) {
+	if err := realtime.PublishToGroup(groupID, frame); err != nil {
+		log.Printf("[Hub] Failed to publish to group %d: %v", groupID, err)
+	}
+}
+
+type localDelivery struct{}
+
+func (localDelivery) DeliverToUser(userID int, frame hub.Frame) {
+	for _, client := range clientsForUser(userID) {
+		switch {
+		case frame.JoinGroup > 0:
+			client.joinGroup(frame.JoinGroup)
+		case frame.LeaveGroup > 0:
+			client.leaveGroup(frame.LeaveGroup)
+		}
+		if frame.ExceptSession == "" || client.Session != frame.ExceptSession {
+			client.queue(frame, !frame.Droppable)
+		}
+	}
+}
+
+// DeliverToGroup reaches the connections here subscribed to the group. Subscriptions are loaded
+// on connect and follow membership changes, so this needs no database lookup per frame.
+func (localDelivery) DeliverToGroup(groupID int, frame hub.Frame) {
+	for _, client := range groupClients(groupID) {
+		if client.ID == frame.ExceptUserID {
+			continue
+		}
+		if frame.ExceptSession == "" || client.Session != frame.ExceptSession {
+			client.queue(frame, !frame.Droppable)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"log"
+	"slices"
+)
+
+// Membership changes, as reported by the group package
+const (
+	MemberJoined      = "joined"
+	MemberLeft        = "left"
+	MemberRemoved     = "removed"
+	MemberRoleChanged = "role_changed"
+)
+
+// GroupMemberChanged tells a group's members, and the member concerned, that someone joined,
+// left, was removed or got a new role
+type GroupMemberChanged struct {
+	Type     string `json:"type"` // "group_member_changed"
+	GroupID  int    `json:"group_id"`
+	UserID   int    `json:"user_id"`
+	Change   string `json:"change"`               // one of the Member* constants
+	Role     string `json:"role,omitempty"`       // the member's role after a join or role change
+	ByUserID int    `json:"by_user_id,omitempty"` // who made the change, when not the member themselves
+}
+
+func (g GroupMemberChanged) EventType() string { return g.Type }
+
+// GroupMembershipChanged is called by the group package once a membership change is committed.
+// The member's live connections join or leave the group's frames right away, on every instance,
+// and the group hears about it.
+func GroupMembershipChanged(change GroupMemberChanged) {
+	change.Type = "group_member_changed"
+	frame, ok := encodeFrame(change)
+	if !ok {
+		return
+	}
+
+	own := frame
+	switch change.Change {
+	case MemberJoined:
+		own.JoinGroup = change.GroupID
+	case MemberLeft, MemberRemoved:
+		own.LeaveGroup = change.GroupID
+	}
+	publishToUser(change.UserID, own)
+
+	frame.ExceptUserID = change.UserID
+	publishToGroup(change.GroupID, frame)
+	log.Printf("[Groups] Membership of user %d in group %d: %s", change.UserID, change.GroupID, change.Change)
+}
+
+func (c *Client) inGroup(groupID int) bool {
+	c.groupsMux.Lock()
+	defer c.groupsMux.Unlock()
+	return slices.Contains(c.Groups, groupID)
+}
+
+func (c *Client) joinGroup(groupID int) {
+	c.groupsMux.Lock()
+	defer c.groupsMux.Unlock()
+	if !slices.Contains(c.Groups, groupID) {
+		c.Groups = append(c.Groups, groupID)
+	}
+}
+
+func (c *Client) leaveGroup(groupID int) {
+	c.groupsMux.Lock()
+	defer c.groupsMux.Unlock()
+	c.Groups = slices.DeleteFunc(c.Groups, func(id int) bool { return id == groupID })
+}
+
+// groupClients snapshots the connections on this instance subscribed to a group
+func groupClients(groupID int) []*Client {
+	ClientsMux.Lock()
+	defer ClientsMux.Unlock()
+
+	var subscribed []*Client
+	for _, sessions := range Clients {
+		for client := range sessions {
+			if client.inGroup(groupID) {
+				subscribed = append(subscribed, client)
+			}
+		}
+	}
+	return subscribed
+}
@@ -1,6 +1,7 @@
 package chat
 
 import (
+	"backend/config"
 	"net/http"
 	"sync"
 
@@ -14,33 +15,138 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// Configure restricts WebSocket upgrades to the allowed origins and sets the connection and chat limits
+func Configure(server config.ServerConfig, ws config.WebSocketConfig, chat config.ChatConfig, uploads config.UploadsConfig,
+	limits config.RateLimitConfig) {
+	sendBufferSize = ws.SendBufferSize
+	writeTimeout = ws.WriteTimeout.Duration
+	pingInterval = ws.PingInterval.Duration
+	pongTimeout = ws.PongTimeout.Duration
+	maxMessageSize = ws.MaxMessageSize
+	editWindow = chat.EditWindow.Duration
+	historyPageSize = chat.HistoryPageSize
+	maxHistoryPageSize = chat.MaxHistoryPageSize
+	maxContentLength = chat.MaxContentLength
+	maxGroupDMSize = chat.MaxGroupDMSize
+	attachmentDir = uploads.ChatDir
+	maxAttachmentSize = uploads.MaxAttachmentSize
+	attachmentTypes = uploads.AttachmentMIMETypes
+	attachmentTTL = uploads.AttachmentTTL.Duration
+	pendingQuota = uploads.PendingQuota
+	messageRate = limits.MessagesPerSecond
+	messageBurst = limits.MessageBurst
+	typingRate = limits.TypingPerSecond
+	typingBurst = limits.TypingBurst
+	maxViolations = limits.MaxViolations
+	violationWindow = limits.ViolationWindow.Duration
+	banDuration = limits.BanDuration.Duration
+
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		// Non-browser clients don't send an Origin header
+		return origin == "" || server.AllowsOrigin(origin)
+	}
+}
+
 type Message struct {
+	ID         int    `json:"id,omitempty"`    // persisted message_id; for "delivered"/"read" frames, the newest message covered
+	Nonce      string `json:"nonce,omitempty"` // client-chosen, echoed back in the ack
 	SenderID   int    `json:"sender_id"`
 	ReceiverID int    `json:"receiver_id,omitempty"`
 	GroupID    int    `json:"group_id,omitempty"`
+	DMID       int    `json:"dm_id,omitempty"` // group DM
 	Content    string `json:"content"`
 	SentAt     string `json:"sent_at"`
-	Type       string `json:"type,omitempty"` // "private", "group", "typing"
+	Type       string `json:"type,omitempty"` // "private", "group", "group_dm", "typing", "delivered", "read", "sync", "edit", "delete", "react", "unreact", "set_status"
 	SenderName string `json:"sender_name,omitempty"`
+	Status     string `json:"status,omitempty"`      // "sent", "delivered" or "read" in history; on "set_status", "available", "away" or "dnd"
+	StatusText string `json:"status_text,omitempty"` // custom text, only on "set_status"
+	EditedAt   string `json:"edited_at,omitempty"`
+	Deleted    bool   `json:"deleted,omitempty"` // tombstone; content is empty
+
+	Emoji     string          `json:"emoji,omitempty"`     // on "react"/"unreact" frames and reaction events
+	Reactions []ReactionCount `json:"reactions,omitempty"` // totals, in history and reaction events
+	ChangeID  int             `json:"change_id,omitempty"` // on edit, delete and reaction events: their place in sync's change stream
+
+	ReplyToID int            `json:"reply_to_id,omitempty"` // parent message in the same conversation
+	ReplyTo   *QuotedMessage `json:"reply_to,omitempty"`    // resolved by the server; ignored on input
+
+	AttachmentID int    `json:"attachment_id,omitempty"` // from POST /chat/attachments; only on input
+	Media        string `json:"media,omitempty"`         // path under /uploads, resolved from the attachment
+	MediaType    string `json:"media_type,omitempty"`
+
+	Since *SyncCursor `json:"since,omitempty"` // only on inbound "sync" frames
+
+	Ref string `json:"-"` // envelope id of the inbound frame, echoed in errors about it
 }
 
 type GroupMessage struct {
+	MessageID      int    `json:"message_id"`
+	GroupID        int    `json:"group_id"`
+	SenderID       int    `json:"sender_id"`
+	Content        string `json:"content"`
+	Media          string `json:"media,omitempty"`
+	MediaType      string `json:"media_type,omitempty"`
+	CreatedAt      string `json:"created_at"`
+	SenderName     string `json:"sender_name,omitempty"`
+	EditedAt       string `json:"edited_at,omitempty"`
+	Deleted        bool   `json:"deleted,omitempty"`
+	DeliveredCount int    `json:"delivered_count"`
+	ReadCount      int    `json:"read_count"`
+
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+	ReplyToID int             `json:"reply_to_id,omitempty"`
+	ReplyTo   *QuotedMessage  `json:"reply_to,omitempty"`
+}
+
+// Ack tells the sending connection which persisted message its nonce became
+type Ack struct {
+	Type   string `json:"type"` // "ack"
+	ID     int    `json:"id"`
+	Nonce  string `json:"nonce,omitempty"`
+	SentAt string `json:"sent_at"`
+
+	Request string `json:"request,omitempty"` // "pending" when the message opened a message request
+}
+
+// Receipt reports that UserID received or read every message up to MessageID
+type Receipt struct {
+	Type       string `json:"type"`   // "receipt"
+	Status     string `json:"status"` // "delivered" or "read"
 	MessageID  int    `json:"message_id"`
-	GroupID    int    `json:"group_id"`
-	SenderID   int    `json:"sender_id"`
-	Content    string `json:"content"`
-	Media      string `json:"media,omitempty"`
-	CreatedAt  string `json:"created_at"`
-	SenderName string `json:"sender_name,omitempty"`
+	UserID     int    `json:"user_id"`
+	ReceiverID int    `json:"receiver_id,omitempty"` // private chats: the other participant
+	GroupID    int    `json:"group_id,omitempty"`
+	At         string `json:"at"`
+}
+
+// UnreadUpdate carries a conversation's new unread count whenever it changes
+type UnreadUpdate struct {
+	Type             string `json:"type"`              // "unread"
+	ConversationType string `json:"conversation_type"` // "user", "group" or "group_dm"
+	ConversationID   int    `json:"conversation_id"`
+	UnreadCount      int    `json:"unread_count"`
+	TotalUnread      int    `json:"total_unread"`    // leaves out muted and archived conversations
+	Muted            bool   `json:"muted,omitempty"` // the conversation is muted: update the badge, don't alert
 }
 
 type Client struct {
-	Conn   *websocket.Conn
-	ID     int
-	Groups []int // Groups the user is a member of
+	Conn    *websocket.Conn // nil for event streams, which drain send themselves
+	ID      int
+	Groups  []int  // Groups the user is a member of, kept current by GroupMembershipChanged; guarded by groupsMux
+	Session string // random id naming this connection across instances
+	Version int    // socket protocol version, chosen by the auth frame
+
+	AuthSession string // login session the connection authenticated with; it is closed once that ends
+
+	groupsMux sync.Mutex
+	send      chan []byte   // outbound frames, drained by writePump
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
 }
 
 var (
-	Clients    = make(map[int]*Client)
+	// Every open connection per user (tabs, devices); a user is online while their set is non-empty
+	Clients    = make(map[int]map[*Client]bool)
 	ClientsMux sync.Mutex
 )
@@ -0,0 +1,142 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// History page sizes, overridden by Configure
+var (
+	historyPageSize    = 20
+	maxHistoryPageSize = 100
+)
+
+// historyPage is a keyset page request for message history:
+//
+//	?before_id=N  messages older than N (scrolling back)
+//	?after_id=N   messages newer than N (catching up)
+//	?around_id=N  N with the messages on either side of it (jumping to a search hit)
+//	none          the newest messages
+//	?limit=       page size, capped at maxHistoryPageSize
+//
+// Requests that still send ?offset= get the old offset paging and a bare array,
+// so existing clients keep working.
+type historyPage struct {
+	BeforeID int
+	AfterID  int
+	AroundID int
+	Limit    int
+
+	Legacy bool
+	Offset int
+}
+
+func parseHistoryPage(query url.Values) historyPage {
+	page := historyPage{
+		BeforeID: queryInt(query.Get("before_id")),
+		AfterID:  queryInt(query.Get("after_id")),
+		AroundID: queryInt(query.Get("around_id")),
+		Limit:    queryInt(query.Get("limit")),
+	}
+	if page.Limit <= 0 {
+		page.Limit = historyPageSize
+	}
+	page.Limit = min(page.Limit, maxHistoryPageSize)
+
+	if query.Has("offset") && page.BeforeID == 0 && page.AfterID == 0 && page.AroundID == 0 {
+		page.Legacy = true
+		page.Offset = queryInt(query.Get("offset"))
+	}
+	return page
+}
+
+// clause returns the SQL appended after a history query's WHERE conditions, and its arguments.
+// One row past the page is fetched so hasMore can be answered without a COUNT.
+func (p historyPage) clause(idColumn string) (string, []interface{}) {
+	switch {
+	case p.Legacy:
+		return " ORDER BY " + idColumn + " DESC LIMIT ? OFFSET ?", []interface{}{p.Limit, p.Offset}
+	case p.AfterID > 0:
+		return " AND " + idColumn + " > ? ORDER BY " + idColumn + " ASC LIMIT ?", []interface{}{p.AfterID, p.Limit + 1}
+	case p.BeforeID > 0:
+		return " AND " + idColumn + " < ? ORDER BY " + idColumn + " DESC LIMIT ?", []interface{}{p.BeforeID, p.Limit + 1}
+	default:
+		return " ORDER BY " + idColumn + " DESC LIMIT ?", []interface{}{p.Limit + 1}
+	}
+}
+
+// finishPage drops the look-ahead row and returns the page newest first, whichever way it was read.
+// hasMore reports whether more messages lie beyond the page in the direction requested.
+func finishPage[T any](p historyPage, rows []T) ([]T, bool) {
+	if p.Legacy {
+		return rows, false
+	}
+
+	hasMore := len(rows) > p.Limit
+	if hasMore {
+		rows = rows[:p.Limit]
+	}
+	if p.AfterID > 0 {
+		slices.Reverse(rows)
+	}
+	return rows, hasMore
+}
+
+// pageInfo says what lies beyond a page. HasMore is in the direction requested;
+// around_id pages report the older side in HasMore and the newer side in HasNewer.
+type pageInfo struct {
+	HasMore  bool
+	HasNewer bool
+}
+
+// loadHistory runs the page query, twice for around_id, and returns the page newest first
+func loadHistory[T any](p historyPage, query func(historyPage) ([]T, error)) ([]T, pageInfo, error) {
+	if p.AroundID == 0 {
+		rows, err := query(p)
+		if err != nil {
+			return nil, pageInfo{}, err
+		}
+		rows, hasMore := finishPage(p, rows)
+		return rows, pageInfo{HasMore: hasMore}, nil
+	}
+
+	// The target itself goes on the older side
+	older := historyPage{BeforeID: p.AroundID + 1, Limit: p.Limit - p.Limit/2}
+	newer := historyPage{AfterID: p.AroundID, Limit: p.Limit / 2}
+
+	olderRows, err := query(older)
+	if err != nil {
+		return nil, pageInfo{}, err
+	}
+	newerRows, err := query(newer)
+	if err != nil {
+		return nil, pageInfo{}, err
+	}
+
+	olderRows, hasOlder := finishPage(older, olderRows)
+	newerRows, hasNewer := finishPage(newer, newerRows)
+	return append(newerRows, olderRows...), pageInfo{HasMore: hasOlder, HasNewer: hasNewer}, nil
+}
+
+// writePage encodes a history page in the shape the request asked for
+func writePage[T any](w http.ResponseWriter, p historyPage, messages []T, info pageInfo) {
+	w.Header().Set("Content-Type", "application/json")
+	if p.Legacy {
+		json.NewEncoder(w).Encode(messages)
+		return
+	}
+
+	if messages == nil {
+		messages = []T{}
+	}
+	response := map[string]interface{}{
+		"messages": messages,
+		"has_more": info.HasMore,
+	}
+	if p.AroundID > 0 {
+		response["has_newer"] = info.HasNewer
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestParseHistoryPage(t *testing.T) {
+	tests := []struct {
+		query string
+		want  historyPage
+	}{
+		{"", historyPage{Limit: historyPageSize}},
+		{"limit=5&before_id=40", historyPage{BeforeID: 40, Limit: 5}},
+		{"limit=-1&after_id=3", historyPage{AfterID: 3, Limit: historyPageSize}},
+		{"limit=100000&around_id=7", historyPage{AroundID: 7, Limit: maxHistoryPageSize}},
+		{"offset=20&limit=10", historyPage{Limit: 10, Legacy: true, Offset: 20}},
+		// Keyset parameters win over an offset sent alongside them
+		{"offset=20&before_id=40", historyPage{BeforeID: 40, Limit: historyPageSize}},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		if got := parseHistoryPage(query); got != tt.want {
+			t.Errorf("parseHistoryPage(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// historyOf stands in for a history query over messages 1..n, reading them the way
+// historyPage.clause orders and limits the SQL
+func historyOf(n int) func(historyPage) ([]int, error) {
+	return func(p historyPage) ([]int, error) {
+		var rows []int
+		switch {
+		case p.Legacy:
+			for id := n - p.Offset; id >= 1 && len(rows) < p.Limit; id-- {
+				rows = append(rows, id)
+			}
+		case p.AfterID > 0:
+			for id := p.AfterID + 1; id <= n && len(rows) < p.Limit+1; id++ {
+				rows = append(rows, id)
+			}
+		case p.BeforeID > 0:
+			for id := min(p.BeforeID-1, n); id >= 1 && len(rows) < p.Limit+1; id-- {
+				rows = append(rows, id)
+			}
+		default:
+			for id := n; id >= 1 && len(rows) < p.Limit+1; id-- {
+				rows = append(rows, id)
+			}
+		}
+		return rows, nil
+	}
+}
+
+func TestLoadHistory(t *testing.T) {
+	tests := []struct {
+		name string
+		page historyPage
+		want []int
+		info pageInfo
+	}{
+		{"newest", historyPage{Limit: 3}, []int{50, 49, 48}, pageInfo{HasMore: true}},
+		{"older", historyPage{BeforeID: 48, Limit: 3}, []int{47, 46, 45}, pageInfo{HasMore: true}},
+		{"oldest", historyPage{BeforeID: 3, Limit: 3}, []int{2, 1}, pageInfo{}},
+		{"exactly the rest", historyPage{BeforeID: 4, Limit: 3}, []int{3, 2, 1}, pageInfo{}},
+		{"newer", historyPage{AfterID: 40, Limit: 3}, []int{43, 42, 41}, pageInfo{HasMore: true}},
+		{"newest after", historyPage{AfterID: 47, Limit: 3}, []int{50, 49, 48}, pageInfo{}},
+		{"nothing newer", historyPage{AfterID: 50, Limit: 3}, nil, pageInfo{}},
+		{"around", historyPage{AroundID: 25, Limit: 5}, []int{27, 26, 25, 24, 23}, pageInfo{HasMore: true, HasNewer: true}},
+		{"around the newest", historyPage{AroundID: 50, Limit: 5}, []int{50, 49, 48}, pageInfo{HasMore: true}},
+		{"around the oldest", historyPage{AroundID: 1, Limit: 5}, []int{3, 2, 1}, pageInfo{HasNewer: true}},
+		{"legacy offset", historyPage{Limit: 3, Legacy: true, Offset: 3}, []int{47, 46, 45}, pageInfo{}},
+	}
+	for _, tt := range tests {
+		got, info, err := loadHistory(tt.page, historyOf(50))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !slices.Equal(got, tt.want) || info != tt.info {
+			t.Errorf("%s: got %v %+v, want %v %+v", tt.name, got, info, tt.want, tt.info)
+		}
+	}
+}
+
+func TestHistoryClause(t *testing.T) {
+	tests := []struct {
+		page historyPage
+		sql  string
+		args []interface{}
+	}{
+		{historyPage{Limit: 10}, " ORDER BY id DESC LIMIT ?", []interface{}{11}},
+		{historyPage{BeforeID: 5, Limit: 10}, " AND id < ? ORDER BY id DESC LIMIT ?", []interface{}{5, 11}},
+		{historyPage{AfterID: 5, Limit: 10}, " AND id > ? ORDER BY id ASC LIMIT ?", []interface{}{5, 11}},
+		{historyPage{Limit: 10, Legacy: true, Offset: 30}, " ORDER BY id DESC LIMIT ? OFFSET ?", []interface{}{10, 30}},
+	}
+	for _, tt := range tests {
+		sql, args := tt.page.clause("id")
+		if sql != tt.sql || !slices.Equal(args, tt.args) {
+			t.Errorf("clause(%+v) = %q %v, want %q %v", tt.page, sql, args, tt.sql, tt.args)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package chat
+
+import (
+	"backend/db"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxStatusTextLength = 100
+
+var errInvalidStatus = errors.New("invalid status")
+
+// Presence is what a user's followers and chat partners can see about them
+type Presence struct {
+	Type       string `json:"type,omitempty"` // "presence" on live events
+	UserID     int    `json:"user_id"`
+	Online     bool   `json:"online"`
+	Status     string `json:"status"` // "available", "away" or "dnd"
+	StatusText string `json:"status_text,omitempty"`
+	LastSeen   string `json:"last_seen,omitempty"` // only while offline
+}
+
+// chatPartners is everyone a user has exchanged private messages with, less those held apart
+// by a request that is pending, declined or blocked. Takes the user id four times.
+const chatPartners = `
+	SELECT partner FROM (
+		SELECT receiver_id AS partner FROM messages WHERE sender_id = ?
+		UNION
+		SELECT sender_id FROM messages WHERE receiver_id = ?
+	) WHERE partner NOT IN (
+		SELECT receiver_id FROM message_requests WHERE sender_id = ? AND status != 'accepted'
+		UNION
+		SELECT sender_id FROM message_requests WHERE receiver_id = ? AND status != 'accepted'
+	)`
+
+// presenceAudience lists who may see a user's presence: accepted followers and anyone
+// they have chatted with. A message request nobody accepted doesn't count.
+func presenceAudience(userID int) ([]int, error) {
+	return queryUserIDs(`
+		SELECT follower_id FROM followers WHERE following_id = ? AND status = 'accepted'
+		UNION`+chatPartners, userID, userID, userID, userID, userID)
+}
+
+// visibleUsers is the reverse of presenceAudience: whose presence viewerID may see
+func visibleUsers(viewerID int) ([]int, error) {
+	return queryUserIDs(`
+		SELECT following_id FROM followers WHERE follower_id = ? AND status = 'accepted'
+		UNION`+chatPartners, viewerID, viewerID, viewerID, viewerID, viewerID)
+}
+
+func queryUserIDs(query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Instance.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// loadPresence reads the stored status and last-seen time; users who never set one are available
+func loadPresence(userID int) Presence {
+	presence := Presence{UserID: userID, Status: "available", Online: IsUserOnline(userID)}
+
+	var statusText sql.NullString
+	var lastSeen sql.NullTime
+	err := db.Instance.QueryRow(`SELECT status, status_text, last_seen_at FROM user_presence WHERE user_id = ?`, userID).
+		Scan(&presence.Status, &statusText, &lastSeen)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to load presence of user %d: %v", userID, err)
+	}
+
+	presence.StatusText = statusText.String
+	if !presence.Online && lastSeen.Valid {
+		presence.LastSeen = lastSeen.Time.Format(time.RFC3339)
+	}
+	return presence
+}
+
+// broadcastPresence sends a user's current presence to everyone allowed to see it, and to
+// the user's own sessions so their other devices show the same status
+func broadcastPresence(userID int) {
+	audience, err := presenceAudience(userID)
+	if err != nil {
+		log.Printf("Failed to load presence audience of user %d: %v", userID, err)
+		return
+	}
+
+	presence := loadPresence(userID)
+	presence.Type = "presence"
+	for _, viewerID := range audience {
+		SendToUser(viewerID, presence)
+	}
+	SendToUser(userID, presence)
+}
+
+// markOnline is called when a user's first session connects
+func markOnline(userID int) {
+	broadcastPresence(userID)
+}
+
+// markOffline records last-seen when a user's last session disconnects
+func markOffline(userID int) {
+	_, err := db.Instance.Exec(`
+		INSERT INTO user_presence (user_id, last_seen_at, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id) DO UPDATE SET last_seen_at = excluded.last_seen_at, updated_at = CURRENT_TIMESTAMP`,
+		userID, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to record last seen of user %d: %v", userID, err)
+	}
+	broadcastPresence(userID)
+}
+
+// presenceSnapshot is the presence of everyone a viewer may see
+func presenceSnapshot(viewerID int) []Presence {
+	ids, err := visibleUsers(viewerID)
+	if err != nil {
+		log.Printf("Failed to load visible users for %d: %v", viewerID, err)
+		return []Presence{}
+	}
+
+	snapshot := make([]Presence, 0, len(ids))
+	for _, id := range ids {
+		snapshot = append(snapshot, loadPresence(id))
+	}
+	return snapshot
+}
+
+// sendPresenceSnapshot gives a new connection its starting state; "presence" deltas follow.
+// online_users is kept for clients that only track who is online.
+func sendPresenceSnapshot(client *Client) {
+	snapshot := presenceSnapshot(client.ID)
+	online := []int{}
+	for _, presence := range snapshot {
+		if presence.Online {
+			online = append(online, presence.UserID)
+		}
+	}
+
+	client.Send(OnlineUsers{Type: "online_users", OnlineUsers: online, Presence: snapshot})
+}
+
+// setStatus validates and stores a user's chosen status
+func setStatus(userID int, status, statusText string) error {
+	statusText = strings.TrimSpace(statusText)
+	if status != "available" && status != "away" && status != "dnd" {
+		return errInvalidStatus
+	}
+	if utf8.RuneCountInString(statusText) > maxStatusTextLength {
+		return errInvalidStatus
+	}
+
+	_, err := db.Instance.Exec(`
+		INSERT INTO user_presence (user_id, status, status_text, updated_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id) DO UPDATE SET status = excluded.status, status_text = excluded.status_text,
+		                                   updated_at = CURRENT_TIMESTAMP`,
+		userID, status, nullableString(statusText))
+	return err
+}
+
+// HandleSetStatus processes {"type":"set_status","status":"away","status_text":"..."}
+func HandleSetStatus(sender *Client, msg Message) {
+	if err := setStatus(sender.ID, msg.Status, msg.StatusText); err != nil {
+		if err != errInvalidStatus {
+			log.Printf("Failed to set status of user %d: %v", sender.ID, err)
+		}
+		sendError(sender, msg, ErrInvalidRequest, "Status must be available, away or dnd, with at most 100 characters of text")
+		return
+	}
+	broadcastPresence(sender.ID)
+}
+
+// PresenceHandler returns the presence of everyone the caller may see: GET /presence
+func PresenceHandler(w http.ResponseWriter, r *http.Request) {
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presenceSnapshot(userID))
+}
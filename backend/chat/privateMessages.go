@@ -6,9 +6,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/gorilla/websocket"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -20,20 +24,33 @@ func HandleConnections(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	var authData struct {
-		Token string `json:"token"`
-	}
-	err = conn.ReadJSON(&authData)
+	// The auth frame is subject to the same limits, so idle unauthenticated sockets are dropped too
+	prepareConn(conn)
+
+	// The auth frame also picks the protocol version for the rest of the connection
+	_, data, err := conn.ReadMessage()
 	if err != nil {
 		log.Println("Failed to read authentication data:", err)
-		conn.WriteJSON(map[string]string{"error": "Invalid token data"})
+		return
+	}
+	authData, version, problem := decodeAuth(data)
+	if problem != nil {
+		log.Printf("Rejected auth frame: %s", problem.Error)
+		writeHandshakeError(conn, version, *problem)
 		return
 	}
 
-	userID, err := user.ExtractUserIDFromToken(authData.Token)
+	claims, err := user.AuthenticateToken(authData.Token)
 	if err != nil {
 		log.Println("Invalid token:", err)
-		conn.WriteJSON(map[string]string{"error": "Unauthorized"})
+		writeHandshakeError(conn, version, ErrorEvent{Type: "error", Code: ErrUnauthorized, Error: "Unauthorized"})
+		return
+	}
+	userID := claims.UserID
+
+	if wait := bannedFor(userID); wait > 0 {
+		writeHandshakeError(conn, version, ErrorEvent{Type: "error", Code: ErrRateLimited,
+			Error: "Too many messages, try again later", RetryAfterMs: int(wait.Milliseconds())})
 		return
 	}
 
@@ -44,87 +61,192 @@ func HandleConnections(w http.ResponseWriter, r *http.Request) {
 		userGroups = []int{} // Continue with empty groups
 	}
 
-	ClientsMux.Lock()
-	Clients[userID] = &Client{Conn: conn, ID: userID, Groups: userGroups}
-	ClientsMux.Unlock()
-
+	// From here on all writes go through the client's write pump
+	client := NewClient(conn, userID, userGroups, version, claims.SessionID)
 	log.Printf("User %d connected with groups: %v", userID, userGroups)
-
-	// Notify all clients about the new online user
-	BroadcastOnlineUsers()
-
-	defer func() {
-		ClientsMux.Lock()
-		delete(Clients, userID)
-		ClientsMux.Unlock()
-		log.Printf("User %d disconnected", userID)
-		BroadcastOnlineUsers()
-	}()
-
-	// Send confirmation to the connected client
-	conn.WriteJSON(map[string]string{"status": "connected", "user_id": fmt.Sprintf("%d", userID)})
+	endSession := openSession(client, CurrentCursor(userID))
+	defer endSession()
 
 	// Listen for messages from the user
 	for {
-		var msg Message
-		err := conn.ReadJSON(&msg)
+		_, data, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("Read error:", err)
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				log.Printf("Reaping idle connection of user %d", userID)
+			} else {
+				log.Println("Read error:", err)
+			}
 			break
 		}
+		extendReadDeadline(conn)
 
-		msg.SenderID = userID
-		msg.SentAt = time.Now().Format(time.RFC3339)
+		msg, problem := decodeFrame(version, data)
+		if problem != nil {
+			client.Send(*problem)
+			continue
+		}
+		if !allowFrame(client, msg) {
+			continue
+		}
+		handleFrame(client, msg)
+	}
+}
 
-		// Get sender name
-		senderName, _ := GetUserName(userID)
-		msg.SenderName = senderName
+// openSession registers a connected client, greets it and announces the user online;
+// the returned func ends the session
+func openSession(client *Client, cursor SyncCursor) func() {
+	firstSession := registerClient(client)
+
+	// Send confirmation to the connected client, with the cursor a later "sync" resumes from
+	client.Send(Connected{
+		Type:    "connected",
+		Status:  "connected",
+		UserID:  fmt.Sprintf("%d", client.ID),
+		Session: client.Session,
+		Cursor:  cursor,
+		Version: client.Version,
+	})
+	sendPresenceSnapshot(client)
 
-		switch msg.Type {
-		case "typing":
-			HandleTypingNotification(msg)
-		case "group":
-			HandleGroupMessage(msg)
-		default: // private message
-			msg.Type = "private"
-			HandlePrivateMessage(msg)
+	// Tell followers and chat partners the user came online; extra tabs/devices don't change presence
+	if firstSession {
+		markOnline(client.ID)
+	}
+
+	return func() {
+		client.Close()
+		lastSession := unregisterClient(client)
+		log.Printf("User %d disconnected", client.ID)
+		if lastSession {
+			markOffline(client.ID)
 		}
 	}
 }
 
-func HandlePrivateMessage(msg Message) {
-	// Check if users can message each other
-	canMessage, err := CanUsersMessage(msg.SenderID, msg.ReceiverID)
+// handleFrame carries out an inbound frame that passed decoding and the rate limits
+func handleFrame(client *Client, msg Message) {
+	msg.SenderID = client.ID
+	msg.SentAt = time.Now().Format(time.RFC3339)
+
+	// Get sender name
+	senderName, _ := GetUserName(client.ID)
+	msg.SenderName = senderName
+
+	if msg.DMID > 0 && notForGroupDMs[msg.Type] {
+		sendError(client, msg, ErrInvalidRequest, "Group DMs don't support "+msg.Type)
+		return
+	}
+
+	switch msg.Type {
+	case "typing":
+		HandleTypingNotification(msg)
+	case "delivered", "read":
+		HandleReceipt(client, msg)
+	case "sync":
+		HandleSync(client, msg)
+	case "edit":
+		HandleEdit(client, msg)
+	case "delete":
+		HandleDelete(client, msg)
+	case "react", "unreact":
+		HandleReaction(client, msg)
+	case "set_status":
+		HandleSetStatus(client, msg)
+	case "group":
+		HandleGroupMessage(client, msg)
+	case "group_dm":
+		HandleGroupDMMessage(client, msg)
+	case "private":
+		HandlePrivateMessage(client, msg)
+	}
+}
+
+// writeHandshakeError answers a failed auth frame; the connection has no writer goroutine yet
+func writeHandshakeError(conn *websocket.Conn, version int, problem ErrorEvent) {
+	if frame, ok := encodeFrame(problem); ok {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		conn.WriteMessage(websocket.TextMessage, frameBytes(frame, version))
+	}
+}
+
+func HandlePrivateMessage(sender *Client, msg Message) {
+	// Check if users can message each other, or whether this opens a message request
+	access, err := privateMessageAccess(msg.SenderID, msg.ReceiverID)
 	if err != nil {
 		log.Printf("Error checking message permissions: %v", err)
+		sendError(sender, msg, ErrInternal, "Failed to send message")
 		return
 	}
 
-	if !canMessage {
-		log.Printf("User %d cannot message user %d - not following or public", msg.SenderID, msg.ReceiverID)
-		// Send error back to sender
-		ClientsMux.Lock()
-		sender, exists := Clients[msg.SenderID]
-		ClientsMux.Unlock()
-		if exists {
-			sender.Conn.WriteJSON(map[string]string{
-				"error": "Cannot send message: You must follow this user or they must have a public profile",
-			})
+	if access == accessDenied {
+		log.Printf("User %d cannot message user %d - message request not accepted", msg.SenderID, msg.ReceiverID)
+		// Send error back to the connection that sent it
+		sendError(sender, msg, ErrForbidden, "Cannot send message: your message request hasn't been accepted")
+		return
+	}
+	msg.ReplyTo = nil
+	if msg.ReplyToID > 0 {
+		if msg.ReplyTo, err = resolveReply("user", msg); err != nil {
+			sendReplyError(sender, msg, err)
+			return
 		}
+	}
+
+	if err := resolveAttachment(&msg); err != nil {
+		sendError(sender, msg, ErrInvalidRequest, "Attachment not found or already used")
+		return
+	}
+	if strings.TrimSpace(msg.Content) == "" && msg.Media == "" {
+		sendError(sender, msg, ErrInvalidRequest, "Message is empty")
 		return
 	}
 	// Save private message
-	SavePrivateMessage(msg)
+	id, err := SavePrivateMessage(msg)
+	if err != nil {
+		sendError(sender, msg, ErrInternal, "Failed to send message")
+		return
+	}
+	msg.ID = id
+	ack := Ack{Type: "ack", ID: id, Nonce: msg.Nonce, SentAt: msg.SentAt}
+	msg.Nonce = ""
+	msg.AttachmentID = 0
+
+	switch access {
+	case accessRequest:
+		if err := openMessageRequest(msg); err != nil {
+			log.Printf("Error opening message request from user %d to user %d: %v", msg.SenderID, msg.ReceiverID, err)
+			if err := discardPrivateMessage(id); err != nil {
+				log.Printf("Error discarding unsent message %d: %v", id, err)
+			}
+			sendError(sender, msg, ErrForbidden, "Cannot send message: your message request hasn't been accepted")
+			return
+		}
+		ack.Request = RequestPending
+		sender.Send(ack)
+		sendToUserExcept(msg.SenderID, sender, msg)
+		return
+	case accessReply:
+		// Answering a pending request accepts it
+		if err := answerMessageRequest(msg.ReceiverID, msg.SenderID, RequestAccepted); err != nil {
+			log.Printf("Error accepting message request by reply: %v", err)
+		}
+	}
+	sender.Send(ack)
 
 	// Forward to recipient if online
 	ForwardPrivateMessage(msg)
+	pushUnread(msg.ReceiverID, "user", msg.SenderID)
+
+	// Keep the sender's other tabs/devices in sync
+	sendToUserExcept(msg.SenderID, sender, msg)
 }
 
 // Update the handleGroupMessage function to include sender_name in the broadcast
-func HandleGroupMessage(msg Message) {
+func HandleGroupMessage(sender *Client, msg Message) {
 	// Check if user is member of the group
 	if !IsUserInGroup(msg.SenderID, msg.GroupID) {
 		log.Printf("User %d is not a member of group %d", msg.SenderID, msg.GroupID)
+		sendError(sender, msg, ErrForbidden, "Not a member of this group")
 		return
 	}
 
@@ -134,17 +256,50 @@ func HandleGroupMessage(msg Message) {
 		msg.SenderName = senderName
 	}
 
+	msg.ReplyTo = nil
+	if msg.ReplyToID > 0 {
+		var err error
+		if msg.ReplyTo, err = resolveReply("group", msg); err != nil {
+			sendReplyError(sender, msg, err)
+			return
+		}
+	}
+
+	if err := resolveAttachment(&msg); err != nil {
+		sendError(sender, msg, ErrInvalidRequest, "Attachment not found or already used")
+		return
+	}
+	if strings.TrimSpace(msg.Content) == "" && msg.Media == "" {
+		sendError(sender, msg, ErrInvalidRequest, "Message is empty")
+		return
+	}
 	// Save group message
-	SaveGroupMessage(msg)
+	id, err := SaveGroupMessage(msg)
+	if err != nil {
+		sendError(sender, msg, ErrInternal, "Failed to send message")
+		return
+	}
+	msg.ID = id
+	sender.Send(Ack{Type: "ack", ID: id, Nonce: msg.Nonce, SentAt: msg.SentAt})
+	msg.Nonce = ""
+	msg.AttachmentID = 0
 
 	// Broadcast to all group members
 	BroadcastToGroupMembers(msg)
+	for _, memberID := range groupMemberIDs(msg.GroupID, msg.SenderID) {
+		pushUnread(memberID, "group", msg.GroupID)
+	}
+
+	// Keep the sender's other tabs/devices in sync
+	sendToUserExcept(msg.SenderID, sender, msg)
 }
 
 func HandleTypingNotification(msg Message) {
 	if msg.GroupID > 0 {
 		// Group typing notification
 		BroadcastTypingToGroup(msg)
+	} else if msg.DMID > 0 {
+		BroadcastTypingToGroupDM(msg)
 	} else {
 		// Private typing notification
 		BroadcastTypingToUser(msg)
@@ -196,7 +351,7 @@ func CanUsersMessage(senderID, receiverID int) (bool, error) {
 	return false, nil
 }
 
-// Updated getMessageableUsersAndGroupsHandler - returns only followed users and user's groups
+// Updated getMessageableUsersAndGroupsHandler - returns followed users, message request partners and user's groups
 func GetMessageableUsersAndGroupsHandler(w http.ResponseWriter, r *http.Request) {
 	userEmail := r.Header.Get("User-Email")
 	if userEmail == "" {
@@ -210,35 +365,56 @@ func GetMessageableUsersAndGroupsHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Get online users
-	ClientsMux.Lock()
-	onlineUserIDs := make(map[int]bool)
-	for id := range Clients {
-		onlineUserIDs[id] = true
-	}
-	ClientsMux.Unlock()
-
 	// Combined result structure
 	type ChatItem struct {
 		ID              int    `json:"id"`
-		Type            string `json:"type"` // "user" or "group"
+		Type            string `json:"type"` // "user", "group" or "group_dm"
 		Name            string `json:"name"`
 		ProfileType     string `json:"profile_type,omitempty"` // only for users
 		LastMessageTime string `json:"last_message_time"`
 		LastMessage     string `json:"last_message,omitempty"`
 		IsOnline        bool   `json:"is_online,omitempty"`    // only for users
-		MemberCount     int    `json:"member_count,omitempty"` // only for groups
+		MemberCount     int    `json:"member_count,omitempty"` // only for groups and group DMs
+		UnreadCount     int    `json:"unread_count"`
+		Muted           bool   `json:"muted"`
+		MutedUntil      string `json:"muted_until,omitempty"`
+		Archived        bool   `json:"archived"`
+		Pinned          bool   `json:"pinned"`
+		Request         string `json:"request,omitempty"` // "pending" while the caller's message request awaits an answer
+		pinnedAt        string
 	}
 
+	// Archived conversations are listed apart: ?archived=true lists only those
+	showArchived := r.URL.Query().Get("archived") == "true"
+	archivedCount := 0
+
 	var chatItems []ChatItem
+	// keep applies the caller's settings to an item and files it in the right list
+	keep := func(item ChatItem, mutedUntil string, archived bool, pinnedAt string) {
+		settings := settingsFrom(item.Type, item.ID, mutedUntil, archived, pinnedAt)
+		item.Muted, item.MutedUntil, item.Archived, item.Pinned = settings.Muted, settings.MutedUntil, settings.Archived, settings.Pinned
+		item.pinnedAt = pinnedAt
+		if item.Archived {
+			archivedCount++
+		}
+		if item.Archived == showArchived {
+			chatItems = append(chatItems, item)
+		}
+	}
 
 	// Get only users that the current user follows (with accepted status)
 	userRows, err := db.Instance.Query(`
 		SELECT DISTINCT u.id, u.nickname, u.profile_type,
-		       COALESCE(latest.created_at, '1970-01-01T00:00:00Z') as last_message_time,
-		       COALESCE(latest.content, '') as last_message
+		       strftime('%Y-%m-%dT%H:%M:%SZ', COALESCE(latest.created_at, '1970-01-01T00:00:00Z')) as last_message_time,
+		       COALESCE(latest.content, '') as last_message,
+		       (SELECT COUNT(*) FROM messages m
+		        WHERE m.sender_id = u.id AND m.receiver_id = ?
+		          AND m.message_id > COALESCE((SELECT last_read_message_id FROM conversation_reads cr
+		              WHERE cr.user_id = ? AND cr.conversation_type = 'user' AND cr.conversation_id = u.id), 0)
+		          AND m.message_id NOT IN (SELECT message_id FROM message_requests WHERE status != 'accepted')) as unread_count,
+		       COALESCE(cs.muted_until, ''), COALESCE(cs.archived, 0), COALESCE(cs.pinned_at, ''),
+		       COALESCE((SELECT status FROM message_requests WHERE sender_id = ? AND receiver_id = u.id AND status = 'pending'), '') as request
 		FROM users u
-		INNER JOIN followers f ON f.following_id = u.id
 		LEFT JOIN (
 			SELECT 
 				CASE 
@@ -252,14 +428,21 @@ func GetMessageableUsersAndGroupsHandler(w http.ResponseWriter, r *http.Request)
 						WHEN sender_id = ? THEN receiver_id 
 						ELSE sender_id 
 					END 
-					ORDER BY created_at DESC
+					ORDER BY message_id DESC
 				) as rn
 			FROM messages 
 			WHERE sender_id = ? OR receiver_id = ?
 		) latest ON u.id = latest.other_user_id AND latest.rn = 1
-		WHERE f.follower_id = ? AND f.status = 'accepted'
+		LEFT JOIN conversation_settings cs ON cs.user_id = ? AND cs.conversation_type = 'user' AND cs.conversation_id = u.id
+		WHERE u.id IN (
+			SELECT following_id FROM followers WHERE follower_id = ? AND status = 'accepted'
+			UNION
+			SELECT receiver_id FROM message_requests WHERE sender_id = ? AND status IN ('pending', 'accepted')
+			UNION
+			SELECT sender_id FROM message_requests WHERE receiver_id = ? AND status = 'accepted'
+		)
 		ORDER BY last_message_time DESC, u.nickname ASC
-	`, userID, userID, userID, userID, userID)
+	`, userID, userID, userID, userID, userID, userID, userID, userID, userID, userID, userID)
 
 	if err != nil {
 		log.Printf("Database query error for users: %v", err)
@@ -270,31 +453,40 @@ func GetMessageableUsersAndGroupsHandler(w http.ResponseWriter, r *http.Request)
 
 	for userRows.Next() {
 		var item ChatItem
-		if err := userRows.Scan(&item.ID, &item.Name, &item.ProfileType, &item.LastMessageTime, &item.LastMessage); err == nil {
+		var mutedUntil, pinnedAt string
+		var archived bool
+		if err := userRows.Scan(&item.ID, &item.Name, &item.ProfileType, &item.LastMessageTime, &item.LastMessage, &item.UnreadCount,
+			&mutedUntil, &archived, &pinnedAt, &item.Request); err == nil {
 			item.Type = "user"
-			item.IsOnline = onlineUserIDs[item.ID]
-			chatItems = append(chatItems, item)
+			item.IsOnline = IsUserOnline(item.ID)
+			keep(item, mutedUntil, archived, pinnedAt)
 		}
 	}
 
 	// Get user's groups with their latest messages
 	groupRows, err := db.Instance.Query(`
 		SELECT g.group_id, g.title, 
-		       COALESCE(latest.created_at, '1970-01-01T00:00:00Z') as last_message_time,
+		       strftime('%Y-%m-%dT%H:%M:%SZ', COALESCE(latest.created_at, '1970-01-01T00:00:00Z')) as last_message_time,
 		       COALESCE(latest.content, '') as last_message,
-		       COUNT(gm2.user_id) as member_count
+		       COUNT(gm2.user_id) as member_count,
+		       (SELECT COUNT(*) FROM group_messages m
+		        WHERE m.group_id = g.group_id AND m.sender_id != ?
+		          AND m.message_id > COALESCE((SELECT last_read_message_id FROM conversation_reads cr
+		              WHERE cr.user_id = ? AND cr.conversation_type = 'group' AND cr.conversation_id = g.group_id), 0)) as unread_count,
+		       COALESCE(cs.muted_until, ''), COALESCE(cs.archived, 0), COALESCE(cs.pinned_at, '')
 		FROM groups g
 		JOIN group_memberships gm ON g.group_id = gm.group_id
 		LEFT JOIN (
 			SELECT group_id, content, created_at,
-			       ROW_NUMBER() OVER (PARTITION BY group_id ORDER BY created_at DESC) as rn
+			       ROW_NUMBER() OVER (PARTITION BY group_id ORDER BY message_id DESC) as rn
 			FROM group_messages
 		) latest ON g.group_id = latest.group_id AND latest.rn = 1
 		LEFT JOIN group_memberships gm2 ON g.group_id = gm2.group_id AND gm2.status = 'accepted'
+		LEFT JOIN conversation_settings cs ON cs.user_id = ? AND cs.conversation_type = 'group' AND cs.conversation_id = g.group_id
 		WHERE gm.user_id = ? AND gm.status = 'accepted'
 		GROUP BY g.group_id
 		ORDER BY last_message_time DESC, g.title ASC
-	`, userID)
+	`, userID, userID, userID, userID)
 
 	if err != nil {
 		log.Printf("Database query error for groups: %v", err)
@@ -305,25 +497,74 @@ func GetMessageableUsersAndGroupsHandler(w http.ResponseWriter, r *http.Request)
 
 	for groupRows.Next() {
 		var item ChatItem
-		if err := groupRows.Scan(&item.ID, &item.Name, &item.LastMessageTime, &item.LastMessage, &item.MemberCount); err == nil {
+		var mutedUntil, pinnedAt string
+		var archived bool
+		if err := groupRows.Scan(&item.ID, &item.Name, &item.LastMessageTime, &item.LastMessage, &item.MemberCount, &item.UnreadCount,
+			&mutedUntil, &archived, &pinnedAt); err == nil {
 			item.Type = "group"
-			chatItems = append(chatItems, item)
+			keep(item, mutedUntil, archived, pinnedAt)
 		}
 	}
 
-	// Sort all items by last message time (most recent first)
-	for i := 0; i < len(chatItems); i++ {
-		for j := i + 1; j < len(chatItems); j++ {
-			if chatItems[i].LastMessageTime < chatItems[j].LastMessageTime {
-				chatItems[i], chatItems[j] = chatItems[j], chatItems[i]
-			}
+	// Group DMs without a title are named after the other participants
+	dmRows, err := db.Instance.Query(`
+		SELECT d.dm_id,
+		       COALESCE(NULLIF(d.title, ''), (SELECT group_concat(u.nickname, ', ') FROM group_dm_participants op
+		           JOIN users u ON u.id = op.user_id WHERE op.dm_id = d.dm_id AND op.user_id != ?), '') as name,
+		       strftime('%Y-%m-%dT%H:%M:%SZ', COALESCE(latest.created_at, d.created_at)) as last_message_time,
+		       COALESCE(latest.content, '') as last_message,
+		       (SELECT COUNT(*) FROM group_dm_participants cp WHERE cp.dm_id = d.dm_id) as member_count,
+		       (SELECT COUNT(*) FROM group_dm_messages m
+		        WHERE m.dm_id = d.dm_id AND m.sender_id != ?
+		          AND m.message_id > MAX(p.last_read_message_id, p.visible_after)) as unread_count,
+		       COALESCE(cs.muted_until, ''), COALESCE(cs.archived, 0), COALESCE(cs.pinned_at, '')
+		FROM group_dms d
+		JOIN group_dm_participants p ON p.dm_id = d.dm_id AND p.user_id = ?
+		LEFT JOIN (
+			SELECT dm_id, message_id, content, created_at,
+			       ROW_NUMBER() OVER (PARTITION BY dm_id ORDER BY message_id DESC) as rn
+			FROM group_dm_messages
+		) latest ON d.dm_id = latest.dm_id AND latest.rn = 1 AND latest.message_id > p.visible_after
+		LEFT JOIN conversation_settings cs ON cs.user_id = ? AND cs.conversation_type = 'group_dm' AND cs.conversation_id = d.dm_id
+		ORDER BY last_message_time DESC
+	`, userID, userID, userID, userID)
+
+	if err != nil {
+		log.Printf("Database query error for group DMs: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer dmRows.Close()
+
+	for dmRows.Next() {
+		var item ChatItem
+		var mutedUntil, pinnedAt string
+		var archived bool
+		if err := dmRows.Scan(&item.ID, &item.Name, &item.LastMessageTime, &item.LastMessage, &item.MemberCount, &item.UnreadCount,
+			&mutedUntil, &archived, &pinnedAt); err == nil {
+			item.Type = "group_dm"
+			keep(item, mutedUntil, archived, pinnedAt)
 		}
 	}
 
+	// Pinned conversations first, most recently pinned on top; then by last message time (most recent first).
+	// The queries above give every last_message_time in UTC as 2006-01-02T15:04:05Z, whatever
+	// format it was stored in, so comparing them as strings orders them by time.
+	sort.SliceStable(chatItems, func(i, j int) bool {
+		if chatItems[i].Pinned != chatItems[j].Pinned {
+			return chatItems[i].Pinned
+		}
+		if chatItems[i].Pinned && chatItems[i].pinnedAt != chatItems[j].pinnedAt {
+			return chatItems[i].pinnedAt > chatItems[j].pinnedAt
+		}
+		return chatItems[i].LastMessageTime > chatItems[j].LastMessageTime
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"chat_items":  chatItems,
-		"total_count": len(chatItems),
+		"chat_items":     chatItems,
+		"total_count":    len(chatItems),
+		"archived_count": archivedCount,
 	})
 }
 
@@ -363,30 +604,60 @@ func GetUserName(userID int) (string, error) {
 	return name, err
 }
 
-func SavePrivateMessage(msg Message) {
-	_, err := db.Instance.Exec("INSERT INTO messages (sender_id, receiver_id, content, created_at) VALUES (?, ?, ?, ?)",
-		msg.SenderID, msg.ReceiverID, msg.Content, msg.SentAt)
+// SavePrivateMessage stores the message, claiming its attachment if any, and returns its message_id
+func SavePrivateMessage(msg Message) (int, error) {
+	return saveMessage("user", msg, "INSERT INTO messages (sender_id, receiver_id, content, media, reply_to_id, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		msg.SenderID, msg.ReceiverID, msg.Content, nullableString(msg.Media), nullableID(msg.ReplyToID), msg.SentAt)
+}
+
+// SaveGroupMessage stores the message, claiming its attachment if any, and returns its message_id
+func SaveGroupMessage(msg Message) (int, error) {
+	return saveMessage("group", msg, "INSERT INTO group_messages (group_id, sender_id, content, media, reply_to_id, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		msg.GroupID, msg.SenderID, msg.Content, nullableString(msg.Media), nullableID(msg.ReplyToID), msg.SentAt)
+}
+
+func saveMessage(kind string, msg Message, query string, args ...interface{}) (int, error) {
+	tx, err := db.Instance.Begin()
 	if err != nil {
-		log.Printf("Failed to save private message: %v", err)
+		return 0, err
 	}
-}
+	defer tx.Rollback()
 
-func SaveGroupMessage(msg Message) {
-	_, err := db.Instance.Exec("INSERT INTO group_messages (group_id, sender_id, content, created_at) VALUES (?, ?, ?, ?)",
-		msg.GroupID, msg.SenderID, msg.Content, msg.SentAt)
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		log.Printf("Failed to save %s message: %v", kind, err)
+		return 0, err
+	}
+	id, err := result.LastInsertId()
 	if err != nil {
-		log.Printf("Failed to save group message: %v", err)
+		return 0, err
+	}
+	if err := claimAttachment(tx, kind, int(id), msg); err != nil {
+		return 0, err
 	}
+	return int(id), tx.Commit()
 }
 
-func ForwardPrivateMessage(msg Message) {
-	ClientsMux.Lock()
-	receiver, exists := Clients[msg.ReceiverID]
-	ClientsMux.Unlock()
+// discardPrivateMessage deletes a saved message that was never sent, freeing its attachment
+// so the sender can try again with it
+func discardPrivateMessage(id int) error {
+	tx, err := db.Instance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	if exists {
-		receiver.Conn.WriteJSON(msg)
+	if err := unclaimAttachment(tx, "user", id); err != nil {
+		return err
 	}
+	if _, err := tx.Exec("DELETE FROM messages WHERE message_id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func ForwardPrivateMessage(msg Message) {
+	SendToUser(msg.ReceiverID, msg)
 }
 
 // Get private messages between two users
@@ -403,48 +674,43 @@ func GetPrivateMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var otherUserID, offset int
+	var otherUserID int
 	fmt.Sscanf(r.URL.Query().Get("other_user"), "%d", &otherUserID)
-	fmt.Sscanf(r.URL.Query().Get("offset"), "%d", &offset)
+	page := parseHistoryPage(r.URL.Query())
 
-	// Check if users can message each other
-	canMessage, err := CanUsersMessage(currentUserID, otherUserID)
+	// Check if users can message each other, or have a message request between them
+	canView, err := canViewConversation(currentUserID, otherUserID)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-	if !canMessage {
+	if !canView {
 		http.Error(w, "Cannot view messages with this user", http.StatusForbidden)
 		return
 	}
 
-	rows, err := db.Instance.Query(`
-		SELECT m.sender_id, m.receiver_id, m.content, m.created_at, u.nickname
-		FROM messages m
-		JOIN users u ON m.sender_id = u.id
-		WHERE (m.sender_id = ? AND m.receiver_id = ?) OR (m.sender_id = ? AND m.receiver_id = ?) 
-		ORDER BY m.created_at DESC 
-		LIMIT 20 OFFSET ?`, currentUserID, otherUserID, otherUserID, currentUserID, offset)
-
+	messages, more, err := loadHistory(page, func(p historyPage) ([]Message, error) {
+		return queryPrivateHistory(currentUserID, otherUserID, p)
+	})
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	var messages []Message
-	for rows.Next() {
-		var msg Message
-		var createdAt time.Time
-		if err := rows.Scan(&msg.SenderID, &msg.ReceiverID, &msg.Content, &createdAt, &msg.SenderName); err == nil {
-			msg.SentAt = createdAt.Format(time.RFC3339)
-			msg.Type = "private"
-			messages = append(messages, msg)
-		}
+	ids := make([]int, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	reactions, err := loadReactions("user", currentUserID, ids)
+	if err != nil {
+		log.Printf("Failed to load reactions: %v", err)
 	}
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+	}
+	attachQuotes("user", messages)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	writePage(w, page, messages, more)
 }
 
 // Update the existing getGroupMessagesHandler to ensure it returns data in the correct format
@@ -461,9 +727,9 @@ func GetGroupMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var groupID, offset int
+	var groupID int
 	fmt.Sscanf(r.URL.Query().Get("group_id"), "%d", &groupID)
-	fmt.Sscanf(r.URL.Query().Get("offset"), "%d", &offset)
+	page := parseHistoryPage(r.URL.Query())
 
 	// Check if user is member of the group
 	if !IsUserInGroup(userID, groupID) {
@@ -471,18 +737,92 @@ func GetGroupMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	messages, more, err := loadHistory(page, func(p historyPage) ([]GroupMessage, error) {
+		return queryGroupHistory(groupID, p)
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	ids := make([]int, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.MessageID
+	}
+	reactions, err := loadReactions("group", userID, ids)
+	if err != nil {
+		log.Printf("Failed to load reactions: %v", err)
+	}
+	var parentIDs []int
+	for _, msg := range messages {
+		if msg.ReplyToID > 0 {
+			parentIDs = append(parentIDs, msg.ReplyToID)
+		}
+	}
+	quotes, err := loadQuotes("group", parentIDs)
+	if err != nil {
+		log.Printf("Failed to load quoted messages: %v", err)
+	}
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].MessageID]
+		messages[i].ReplyTo = quotes[messages[i].ReplyToID]
+	}
+
+	writePage(w, page, messages, more)
+}
+
+// queryPrivateHistory reads one page of the conversation between two users, in the page's scan order
+func queryPrivateHistory(currentUserID, otherUserID int, p historyPage) ([]Message, error) {
+	pageClause, pageArgs := p.clause("m.message_id")
+	rows, err := db.Instance.Query(`
+		SELECT m.message_id, m.sender_id, m.receiver_id, m.content, m.created_at, u.nickname,
+		       m.delivered_at, m.read_at, m.edited_at, m.deleted_at IS NOT NULL, COALESCE(m.reply_to_id, 0),
+		       COALESCE(m.media, ''), COALESCE(a.mime_type, '')
+		FROM messages m
+		JOIN users u ON m.sender_id = u.id
+		LEFT JOIN chat_attachments a ON a.conversation_type = 'user' AND a.message_id = m.message_id
+		WHERE ((m.sender_id = ? AND m.receiver_id = ?) OR (m.sender_id = ? AND m.receiver_id = ?))`+pageClause,
+		append([]interface{}{currentUserID, otherUserID, otherUserID, currentUserID}, pageArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		var createdAt time.Time
+		var deliveredAt, readAt, editedAt *string
+		if err := rows.Scan(&msg.ID, &msg.SenderID, &msg.ReceiverID, &msg.Content, &createdAt, &msg.SenderName,
+			&deliveredAt, &readAt, &editedAt, &msg.Deleted, &msg.ReplyToID, &msg.Media, &msg.MediaType); err == nil {
+			msg.SentAt = createdAt.Format(time.RFC3339)
+			msg.Type = "private"
+			msg.Status = messageStatus(deliveredAt, readAt)
+			if editedAt != nil {
+				msg.EditedAt = *editedAt
+			}
+			messages = append(messages, msg)
+		}
+	}
+	return messages, rows.Err()
+}
+
+// queryGroupHistory reads one page of a group's messages, in the page's scan order
+func queryGroupHistory(groupID int, p historyPage) ([]GroupMessage, error) {
+	pageClause, pageArgs := p.clause("gm.message_id")
 	rows, err := db.Instance.Query(`
 		SELECT gm.message_id, gm.group_id, gm.sender_id, gm.content, 
-		       COALESCE(gm.media, '') as media, gm.created_at, u.nickname
+		       COALESCE(gm.media, '') as media, COALESCE(a.mime_type, '') as media_type, gm.created_at, u.nickname,
+		       COALESCE(gm.edited_at, '') as edited_at, gm.deleted_at IS NOT NULL as deleted,
+		       COALESCE(gm.reply_to_id, 0) as reply_to_id,
+		       (SELECT COUNT(*) FROM group_message_receipts r WHERE r.message_id = gm.message_id) as delivered_count,
+		       (SELECT COUNT(*) FROM group_message_receipts r WHERE r.message_id = gm.message_id AND r.read_at IS NOT NULL) as read_count
 		FROM group_messages gm
 		JOIN users u ON gm.sender_id = u.id
-		WHERE gm.group_id = ?
-		ORDER BY gm.created_at DESC 
-		LIMIT 20 OFFSET ?`, groupID, offset)
-
+		LEFT JOIN chat_attachments a ON a.conversation_type = 'group' AND a.message_id = gm.message_id
+		WHERE gm.group_id = ?`+pageClause, append([]interface{}{groupID}, pageArgs...)...)
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -491,12 +831,11 @@ func GetGroupMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		var msg GroupMessage
 		var createdAt time.Time
 		if err := rows.Scan(&msg.MessageID, &msg.GroupID, &msg.SenderID, &msg.Content,
-			&msg.Media, &createdAt, &msg.SenderName); err == nil {
+			&msg.Media, &msg.MediaType, &createdAt, &msg.SenderName, &msg.EditedAt, &msg.Deleted, &msg.ReplyToID,
+			&msg.DeliveredCount, &msg.ReadCount); err == nil {
 			msg.CreatedAt = createdAt.Format(time.RFC3339)
 			messages = append(messages, msg)
 		}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	return messages, rows.Err()
 }
@@ -0,0 +1,309 @@
+package chat
+
+import (
+	"backend/pkg/hub"
+	"encoding/json"
+	"log"
+)
+
+// ProtocolVersion is the enveloped socket protocol. A client opts in by sending its auth frame
+// as an envelope; any other auth frame selects the legacy protocol (version 1), where frames are
+// the bare payloads and an inbound frame without a type is a private message.
+const ProtocolVersion = 2
+
+// Envelope wraps every frame of protocol version 2, in both directions:
+//
+//	{"type":"private","id":"…","version":2,"payload":{…}}
+//
+// type selects the payload struct (see inboundEvents and outboundEvents). id is chosen by
+// whoever sends the frame; errors caused by an inbound frame carry its id as "ref".
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Version int             `json:"version"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Error codes carried by ErrorEvent
+const (
+	ErrInvalidFrame       = "invalid_frame"       // not JSON, or not a valid envelope
+	ErrUnsupportedVersion = "unsupported_version" // envelope version the server doesn't speak
+	ErrUnknownType        = "unknown_type"        // no such inbound frame type
+	ErrUnauthorized       = "unauthorized"
+	ErrInvalidRequest     = "invalid_request" // a required field is missing or malformed
+	ErrForbidden          = "forbidden"
+	ErrNotFound           = "not_found"
+	ErrRateLimited        = "rate_limited" // see retry_after_ms
+	ErrInternal           = "internal"
+)
+
+// Event is implemented by every outbound frame; EventType is its envelope type
+type Event interface {
+	EventType() string
+}
+
+// AuthFrame is the first frame on every connection
+type AuthFrame struct {
+	Token string `json:"token"`
+}
+
+// Connected confirms the auth frame, with the cursor a later "sync" resumes from
+type Connected struct {
+	Type    string     `json:"type"`    // "connected"
+	Status  string     `json:"status"`  // "connected"; what legacy clients look for
+	UserID  string     `json:"user_id"` // a string, as legacy clients expect
+	Session string     `json:"session"` // names this connection; see StreamSendHandler
+	Cursor  SyncCursor `json:"cursor"`
+	Version int        `json:"version"` // the protocol the connection speaks
+}
+
+// ErrorEvent reports a request the server refused or could not carry out
+type ErrorEvent struct {
+	Type  string `json:"type"` // "error"
+	Code  string `json:"code"`
+	Error string `json:"error"`           // human-readable
+	ID    int    `json:"id,omitempty"`    // the message the request was about
+	Nonce string `json:"nonce,omitempty"` // echoed from the request
+	Ref   string `json:"ref,omitempty"`   // envelope id of the request
+
+	RetryAfterMs int `json:"retry_after_ms,omitempty"` // rate_limited: when to try again
+}
+
+// OnlineUsers is the presence snapshot sent after Connected; Presence deltas follow
+type OnlineUsers struct {
+	Type        string     `json:"type"`         // "online_users"
+	OnlineUsers []int      `json:"online_users"` // for clients that only track who is online
+	Presence    []Presence `json:"presence"`
+}
+
+func (m Message) EventType() string      { return m.Type }
+func (a Ack) EventType() string          { return a.Type }
+func (r Receipt) EventType() string      { return r.Type }
+func (u UnreadUpdate) EventType() string { return u.Type }
+func (b SyncBatch) EventType() string    { return b.Type }
+func (p Presence) EventType() string     { return p.Type }
+func (c Connected) EventType() string    { return c.Type }
+func (e ErrorEvent) EventType() string   { return e.Type }
+func (o OnlineUsers) EventType() string  { return o.Type }
+
+// Inbound payloads of protocol version 2. Each becomes the Message the handlers take,
+// so both protocol versions share one code path.
+type inbound interface {
+	message() Message
+}
+
+// SendMessage is the payload of "private" (receiver_id), "group" (group_id) and "group_dm" (dm_id)
+type SendMessage struct {
+	ReceiverID   int    `json:"receiver_id,omitempty"`
+	GroupID      int    `json:"group_id,omitempty"`
+	DMID         int    `json:"dm_id,omitempty"`
+	Content      string `json:"content"`
+	Nonce        string `json:"nonce,omitempty"`
+	ReplyToID    int    `json:"reply_to_id,omitempty"`
+	AttachmentID int    `json:"attachment_id,omitempty"`
+}
+
+// TypingRequest is the payload of "typing"
+type TypingRequest struct {
+	ReceiverID int `json:"receiver_id,omitempty"`
+	GroupID    int `json:"group_id,omitempty"`
+	DMID       int `json:"dm_id,omitempty"`
+}
+
+// ReceiptRequest is the payload of "delivered" and "read": everything up to ID in the conversation.
+// Group DMs only take "read".
+type ReceiptRequest struct {
+	ID         int `json:"id"`
+	ReceiverID int `json:"receiver_id,omitempty"`
+	GroupID    int `json:"group_id,omitempty"`
+	DMID       int `json:"dm_id,omitempty"`
+}
+
+// SyncRequest is the payload of "sync"
+type SyncRequest struct {
+	Since *SyncCursor `json:"since,omitempty"`
+}
+
+// ChangeRequest is the payload of "edit" (with content), "delete", "react" and "unreact" (with emoji);
+// group_id selects a group message. Group DM messages can't be changed, so dm_id is refused.
+type ChangeRequest struct {
+	ID      int    `json:"id"`
+	GroupID int    `json:"group_id,omitempty"`
+	DMID    int    `json:"dm_id,omitempty"`
+	Content string `json:"content,omitempty"`
+	Emoji   string `json:"emoji,omitempty"`
+	Nonce   string `json:"nonce,omitempty"`
+}
+
+// StatusRequest is the payload of "set_status"
+type StatusRequest struct {
+	Status     string `json:"status"` // "available", "away" or "dnd"
+	StatusText string `json:"status_text,omitempty"`
+}
+
+func (s SendMessage) message() Message {
+	return Message{ReceiverID: s.ReceiverID, GroupID: s.GroupID, DMID: s.DMID, Content: s.Content, Nonce: s.Nonce,
+		ReplyToID: s.ReplyToID, AttachmentID: s.AttachmentID}
+}
+
+func (t TypingRequest) message() Message {
+	return Message{ReceiverID: t.ReceiverID, GroupID: t.GroupID, DMID: t.DMID}
+}
+
+func (r ReceiptRequest) message() Message {
+	return Message{ID: r.ID, ReceiverID: r.ReceiverID, GroupID: r.GroupID, DMID: r.DMID}
+}
+
+func (s SyncRequest) message() Message {
+	return Message{Since: s.Since}
+}
+
+func (c ChangeRequest) message() Message {
+	return Message{ID: c.ID, GroupID: c.GroupID, DMID: c.DMID, Content: c.Content, Emoji: c.Emoji, Nonce: c.Nonce}
+}
+
+func (s StatusRequest) message() Message {
+	return Message{Status: s.Status, StatusText: s.StatusText}
+}
+
+// inboundEvents maps each frame type a client may send to a constructor for its payload
+var inboundEvents = map[string]func() inbound{
+	"private":    func() inbound { return &SendMessage{} },
+	"group":      func() inbound { return &SendMessage{} },
+	"group_dm":   func() inbound { return &SendMessage{} },
+	"typing":     func() inbound { return &TypingRequest{} },
+	"delivered":  func() inbound { return &ReceiptRequest{} },
+	"read":       func() inbound { return &ReceiptRequest{} },
+	"sync":       func() inbound { return &SyncRequest{} },
+	"edit":       func() inbound { return &ChangeRequest{} },
+	"delete":     func() inbound { return &ChangeRequest{} },
+	"react":      func() inbound { return &ChangeRequest{} },
+	"unreact":    func() inbound { return &ChangeRequest{} },
+	"set_status": func() inbound { return &StatusRequest{} },
+}
+
+// outboundEvents maps each frame type the server sends to its payload struct, for the schema
+var outboundEvents = map[string]Event{
+	"connected":             Connected{},
+	"error":                 ErrorEvent{},
+	"private":               Message{},
+	"group":                 Message{},
+	"group_dm":              Message{},
+	"typing":                Message{},
+	"ack":                   Ack{},
+	"receipt":               Receipt{},
+	"unread":                UnreadUpdate{},
+	"sync":                  SyncBatch{},
+	"message_edited":        Message{},
+	"message_deleted":       Message{},
+	"reaction_added":        Message{},
+	"reaction_removed":      Message{},
+	"presence":              Presence{},
+	"online_users":          OnlineUsers{},
+	"group_member_changed":  GroupMemberChanged{},
+	"conversation_settings": ConversationSettings{},
+	"message_request":       MessageRequest{},
+	"group_dm_changed":      GroupDMChanged{},
+}
+
+// RegisterEvent adds an outbound frame defined outside this package to the schema
+func RegisterEvent(eventType string, payload Event) {
+	outboundEvents[eventType] = payload
+}
+
+// decodeAuth reads the first frame of a connection, which also decides its protocol version
+func decodeAuth(data []byte) (AuthFrame, int, *ErrorEvent) {
+	var frame struct {
+		Envelope
+		AuthFrame
+	}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return AuthFrame{}, 1, &ErrorEvent{Type: "error", Code: ErrInvalidFrame, Error: "Invalid token data"}
+	}
+	if frame.Version < ProtocolVersion {
+		return frame.AuthFrame, 1, nil
+	}
+
+	problem := &ErrorEvent{Type: "error", Code: ErrInvalidFrame, Error: "Invalid token data", Ref: frame.ID}
+	if frame.Version > ProtocolVersion {
+		problem.Code, problem.Error = ErrUnsupportedVersion, "Unsupported protocol version"
+		return AuthFrame{}, ProtocolVersion, problem
+	}
+	var auth AuthFrame
+	if frame.Type != "auth" || json.Unmarshal(frame.Payload, &auth) != nil {
+		return AuthFrame{}, ProtocolVersion, problem
+	}
+	return auth, ProtocolVersion, nil
+}
+
+// decodeFrame turns an inbound frame of either protocol version into the Message handlers take
+func decodeFrame(version int, data []byte) (Message, *ErrorEvent) {
+	var msg Message
+	if version < ProtocolVersion {
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return msg, &ErrorEvent{Type: "error", Code: ErrInvalidFrame, Error: "Frame is not valid JSON"}
+		}
+		// Legacy clients send private messages without a type
+		if msg.Type == "" {
+			msg.Type = "private"
+		}
+		if _, known := inboundEvents[msg.Type]; !known {
+			return msg, unknownType(msg.Type, "", msg.Nonce)
+		}
+		return msg, nil
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return msg, &ErrorEvent{Type: "error", Code: ErrInvalidFrame, Error: "Frame is not a valid envelope"}
+	}
+	if env.Version != ProtocolVersion {
+		return msg, &ErrorEvent{Type: "error", Code: ErrUnsupportedVersion, Error: "Unsupported protocol version", Ref: env.ID}
+	}
+	newPayload, known := inboundEvents[env.Type]
+	if !known {
+		return msg, unknownType(env.Type, env.ID, "")
+	}
+
+	payload := newPayload()
+	if len(env.Payload) > 0 {
+		if err := json.Unmarshal(env.Payload, payload); err != nil {
+			return msg, &ErrorEvent{Type: "error", Code: ErrInvalidFrame, Error: "Payload does not match type " + env.Type, Ref: env.ID}
+		}
+	}
+	msg = payload.message()
+	msg.Type = env.Type
+	msg.Ref = env.ID
+	return msg, nil
+}
+
+func unknownType(eventType, ref, nonce string) *ErrorEvent {
+	return &ErrorEvent{Type: "error", Code: ErrUnknownType, Error: "Unknown frame type " + eventType, Ref: ref, Nonce: nonce}
+}
+
+// sendError tells the connection that sent msg why it was refused
+func sendError(sender *Client, msg Message, code, text string) {
+	sender.Send(ErrorEvent{Type: "error", Code: code, Error: text, ID: msg.ID, Nonce: msg.Nonce, Ref: msg.Ref})
+}
+
+func encodeFrame(v Event) (hub.Frame, bool) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding %s frame: %v", v.EventType(), err)
+		return hub.Frame{}, false
+	}
+	return hub.Frame{Type: v.EventType(), ID: randomID(), Data: data}, true
+}
+
+// frameBytes is a frame as written to a connection speaking the given protocol version
+func frameBytes(frame hub.Frame, version int) []byte {
+	if version < ProtocolVersion {
+		return frame.Data
+	}
+	data, err := json.Marshal(Envelope{Type: frame.Type, ID: frame.ID, Version: ProtocolVersion, Payload: frame.Data})
+	if err != nil {
+		log.Printf("Error wrapping %s frame: %v", frame.Type, err)
+		return frame.Data
+	}
+	return data
+}
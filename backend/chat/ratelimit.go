@@ -0,0 +1,185 @@
+package chat
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Socket limits, overridden by Configure. They are kept per user, so opening more tabs
+// doesn't buy more throughput, and per instance, so a user spread over replicas gets each one's.
+var (
+	maxContentLength = 4000
+
+	messageRate     = 5.0
+	messageBurst    = 20
+	typingRate      = 4.0
+	typingBurst     = 8
+	maxViolations   = 10
+	violationWindow = time.Minute
+	banDuration     = 5 * time.Minute
+)
+
+const limitsIdleTimeout = 10 * time.Minute
+
+// tokenBucket refills at rate tokens per second up to burst; each frame spends one
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take spends a token, or reports how long until one is available
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) (bool, time.Duration) {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// userLimits is one user's buckets and record of refused frames
+type userLimits struct {
+	messages tokenBucket
+	typing   tokenBucket
+
+	violations     int
+	windowStart    time.Time
+	typingNotified bool // told about dropped typing frames since the last one got through
+	bannedUntil    time.Time
+	lastUsed       time.Time
+}
+
+var (
+	limits    = make(map[int]*userLimits)
+	limitsMux sync.Mutex
+	lastSweep time.Time
+)
+
+// limitsFor returns a user's limits, creating them and now and then forgetting idle users.
+// Callers hold limitsMux.
+func limitsFor(userID int, now time.Time) *userLimits {
+	if now.Sub(lastSweep) > time.Minute {
+		lastSweep = now
+		for id, l := range limits {
+			if now.Sub(l.lastUsed) > limitsIdleTimeout && now.After(l.bannedUntil) {
+				delete(limits, id)
+			}
+		}
+	}
+
+	l, ok := limits[userID]
+	if !ok {
+		l = &userLimits{}
+		limits[userID] = l
+	}
+	l.lastUsed = now
+	return l
+}
+
+// bannedFor reports how long a user is still turned away after being disconnected
+func bannedFor(userID int) time.Duration {
+	limitsMux.Lock()
+	defer limitsMux.Unlock()
+
+	l, ok := limits[userID]
+	if !ok {
+		return 0
+	}
+	return max(0, time.Until(l.bannedUntil))
+}
+
+// rateVerdict is what checkRate decided about one inbound frame
+type rateVerdict struct {
+	allowed    bool
+	notify     bool // tell the client; false for repeated typing drops
+	ban        bool // too many refusals: disconnect the user
+	retryAfter time.Duration
+}
+
+// checkRate spends a token for an inbound frame. Typing frames have their own bucket and,
+// since clients send one per keystroke, dropping them never counts against the user.
+func checkRate(userID int, typing bool) rateVerdict {
+	limitsMux.Lock()
+	defer limitsMux.Unlock()
+
+	now := time.Now()
+	l := limitsFor(userID, now)
+
+	if typing {
+		ok, wait := l.typing.take(now, typingRate, typingBurst)
+		if ok {
+			l.typingNotified = false
+			return rateVerdict{allowed: true}
+		}
+		notify := !l.typingNotified
+		l.typingNotified = true
+		return rateVerdict{notify: notify, retryAfter: wait}
+	}
+
+	ok, wait := l.messages.take(now, messageRate, messageBurst)
+	if ok {
+		return rateVerdict{allowed: true}
+	}
+
+	if now.Sub(l.windowStart) > violationWindow {
+		l.windowStart, l.violations = now, 0
+	}
+	l.violations++
+	if l.violations < maxViolations {
+		return rateVerdict{notify: true, retryAfter: wait}
+	}
+
+	l.violations = 0
+	l.bannedUntil = now.Add(banDuration)
+	return rateVerdict{notify: true, ban: true, retryAfter: banDuration}
+}
+
+// allowFrame applies the size and rate limits to an inbound frame before it is handled,
+// telling the client why when it is refused
+func allowFrame(client *Client, msg Message) bool {
+	if utf8.RuneCountInString(msg.Content) > maxContentLength {
+		sendError(client, msg, ErrInvalidRequest, fmt.Sprintf("Message is longer than %d characters", maxContentLength))
+		return false
+	}
+
+	verdict := checkRate(client.ID, msg.Type == "typing")
+	if verdict.allowed {
+		return true
+	}
+	if !verdict.notify {
+		return false
+	}
+
+	problem := ErrorEvent{
+		Type:         "error",
+		Code:         ErrRateLimited,
+		Error:        "Too many messages, slow down",
+		ID:           msg.ID,
+		Nonce:        msg.Nonce,
+		Ref:          msg.Ref,
+		RetryAfterMs: int(verdict.retryAfter.Milliseconds()),
+	}
+	if !verdict.ban {
+		// Don't let the refusals themselves overflow a flooding client's queue
+		client.TrySend(problem)
+		return false
+	}
+
+	log.Printf("[RateLimit] User %d kept exceeding limits, disconnecting for %v", client.ID, banDuration)
+	problem.Error = "Too many messages, disconnected for a while"
+	for _, session := range clientsForUser(client.ID) {
+		session.TrySend(problem)
+		session.Close()
+	}
+	return false
+}
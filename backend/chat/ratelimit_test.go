@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	start := time.Now()
+
+	// A new bucket starts full
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.take(start, 2, 3); !ok {
+			t.Fatalf("token %d of a full bucket refused", i)
+		}
+	}
+	ok, wait := b.take(start, 2, 3)
+	if ok {
+		t.Fatal("empty bucket gave a token")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("wait = %v, want 500ms at 2 tokens a second", wait)
+	}
+
+	// Half a second refills one token
+	if ok, _ := b.take(start.Add(500*time.Millisecond), 2, 3); !ok {
+		t.Error("refilled token refused")
+	}
+	if ok, _ := b.take(start.Add(500*time.Millisecond), 2, 3); ok {
+		t.Error("more than the refill was spent")
+	}
+
+	// A long pause refills no further than the burst
+	later := start.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.take(later, 2, 3); !ok {
+			t.Fatalf("token %d after a pause refused", i)
+		}
+	}
+	if ok, _ := b.take(later, 2, 3); ok {
+		t.Error("bucket refilled past its burst")
+	}
+}
+
+func TestCheckRate(t *testing.T) {
+	defer func(rate, tRate float64, burst, tBurst, violations int, ban time.Duration) {
+		messageRate, typingRate, messageBurst, typingBurst, maxViolations, banDuration = rate, tRate, burst, tBurst, violations, ban
+	}(messageRate, typingRate, messageBurst, typingBurst, maxViolations, banDuration)
+	// Slow enough that nothing refills while the test runs
+	messageRate, messageBurst, maxViolations, banDuration = 0.001, 2, 3, time.Minute
+	typingRate, typingBurst = 0.001, 1
+
+	const userID = 9001
+	defer func() {
+		limitsMux.Lock()
+		delete(limits, userID)
+		limitsMux.Unlock()
+	}()
+
+	t.Run("messages", func(t *testing.T) {
+		for i := 0; i < messageBurst; i++ {
+			if v := checkRate(userID, false); !v.allowed {
+				t.Fatalf("message %d within the burst refused", i)
+			}
+		}
+		for i := 1; i < maxViolations; i++ {
+			v := checkRate(userID, false)
+			if v.allowed || !v.notify || v.ban {
+				t.Fatalf("refusal %d: %+v, want a notified refusal", i, v)
+			}
+		}
+		v := checkRate(userID, false)
+		if !v.ban || v.retryAfter != banDuration {
+			t.Fatalf("refusal %d: %+v, want a ban for %v", maxViolations, v, banDuration)
+		}
+		if wait := bannedFor(userID); wait <= 0 || wait > banDuration {
+			t.Errorf("bannedFor = %v, want up to %v", wait, banDuration)
+		}
+	})
+
+	t.Run("typing", func(t *testing.T) {
+		if v := checkRate(userID, true); !v.allowed {
+			t.Fatal("typing frame refused while messages are limited")
+		}
+		if v := checkRate(userID, true); v.allowed || !v.notify {
+			t.Fatalf("first dropped typing frame: %+v, want a notified refusal", v)
+		}
+		for i := 0; i < maxViolations*2; i++ {
+			if v := checkRate(userID, true); v.allowed || v.notify || v.ban {
+				t.Fatalf("repeated typing drop: %+v, want a silent drop that never bans", v)
+			}
+		}
+	})
+}
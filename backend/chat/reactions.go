@@ -0,0 +1,166 @@
+package chat
+
+import (
+	"backend/db"
+	"log"
+	"strings"
+)
+
+// maxEmojiLength bounds a reaction; enough for skin tones and ZWJ sequences
+const maxEmojiLength = 32
+
+// ReactionCount aggregates one emoji on one message
+type ReactionCount struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted,omitempty"` // the requesting user is among them; only in history
+}
+
+// HandleReaction processes {"type":"react"|"unreact","id":N,"emoji":"..."}; group_id selects a group message.
+// The resulting "reaction_added"/"reaction_removed" event carries the reactor as sender_id
+// and the message's new totals.
+func HandleReaction(sender *Client, msg Message) {
+	emoji := strings.TrimSpace(msg.Emoji)
+	if msg.ID <= 0 || len(emoji) > maxEmojiLength || !isEmoji(emoji) {
+		sendError(sender, msg, ErrInvalidRequest, "Reaction requires a message id and an emoji")
+		return
+	}
+
+	kind := "user"
+	if msg.GroupID > 0 {
+		kind = "group"
+	}
+
+	stored, err := loadStoredMessage(kind, msg.ID)
+	if err == nil && stored.Deleted {
+		err = errMessageDeleted
+	}
+	if err == nil && !canSeeMessage(stored, msg.SenderID) {
+		err = errMessageNotFound
+	}
+	if err != nil {
+		sendChangeError(sender, msg, err)
+		return
+	}
+	if stored.GroupID == 0 {
+		otherID := stored.ReceiverID
+		if msg.SenderID == stored.ReceiverID {
+			otherID = stored.SenderID
+		}
+		if !canReach(msg.SenderID, otherID) {
+			sendError(sender, msg, ErrForbidden, "Cannot react until the message request is accepted")
+			return
+		}
+	}
+
+	query := `INSERT OR IGNORE INTO message_reactions (conversation_type, message_id, user_id, emoji) VALUES (?, ?, ?, ?)`
+	eventType := "reaction_added"
+	if msg.Type == "unreact" {
+		query = `DELETE FROM message_reactions WHERE conversation_type = ? AND message_id = ? AND user_id = ? AND emoji = ?`
+		eventType = "reaction_removed"
+	}
+
+	changeID, err := storeReaction(query, kind, stored.ID, msg.SenderID, emoji, eventType)
+	if err != nil {
+		log.Printf("Failed to store reaction on message %d: %v", stored.ID, err)
+		sendChangeError(sender, msg, err)
+		return
+	}
+	// Reacting twice or removing a reaction that isn't there changes nothing
+	if changeID == 0 {
+		return
+	}
+
+	reactions, err := loadReactions(kind, 0, []int{stored.ID})
+	if err != nil {
+		log.Printf("Failed to load reactions for message %d: %v", stored.ID, err)
+	}
+
+	event := Message{
+		Type:       eventType,
+		ID:         stored.ID,
+		SenderID:   msg.SenderID,
+		SenderName: msg.SenderName,
+		GroupID:    stored.GroupID,
+		Emoji:      emoji,
+		Reactions:  reactions[stored.ID],
+		ChangeID:   changeID,
+	}
+	if stored.GroupID > 0 {
+		BroadcastToGroupMembers(event)
+	} else {
+		// Whichever participant reacted, the event goes to the other one
+		event.ReceiverID = stored.ReceiverID
+		if msg.SenderID == stored.ReceiverID {
+			event.ReceiverID = stored.SenderID
+		}
+		ForwardPrivateMessage(event)
+	}
+	SendToUser(msg.SenderID, event)
+}
+
+// storeReaction runs the add or remove query and logs the change with it. It returns the
+// change_id, or 0 when the query changed nothing.
+func storeReaction(query, kind string, messageID, userID int, emoji, change string) (int, error) {
+	tx, err := db.Instance.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, kind, messageID, userID, emoji)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, nil
+	}
+	changeID, err := recordChange(tx, kind, messageID, change, userID, emoji)
+	if err != nil {
+		return 0, err
+	}
+	return changeID, tx.Commit()
+}
+
+// canSeeMessage reports whether a user is part of the conversation a message belongs to
+func canSeeMessage(m storedMessage, userID int) bool {
+	if m.GroupID > 0 {
+		return IsUserInGroup(userID, m.GroupID)
+	}
+	return userID == m.SenderID || userID == m.ReceiverID
+}
+
+// loadReactions aggregates reactions for a page of messages, oldest emoji first.
+// viewerID marks the emojis that user reacted with; pass 0 for events shared by everyone.
+func loadReactions(kind string, viewerID int, messageIDs []int) (map[int][]ReactionCount, error) {
+	reactions := make(map[int][]ReactionCount)
+	if len(messageIDs) == 0 {
+		return reactions, nil
+	}
+
+	args := []interface{}{viewerID, kind}
+	for _, id := range messageIDs {
+		args = append(args, id)
+	}
+
+	rows, err := db.Instance.Query(`
+		SELECT message_id, emoji, COUNT(*), MAX(user_id = ?)
+		FROM message_reactions
+		WHERE conversation_type = ? AND message_id IN (?`+strings.Repeat(",?", len(messageIDs)-1)+`)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at), emoji`, args...)
+	if err != nil {
+		return reactions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var reaction ReactionCount
+		if err := rows.Scan(&messageID, &reaction.Emoji, &reaction.Count, &reaction.Reacted); err != nil {
+			return reactions, err
+		}
+		reactions[messageID] = append(reactions[messageID], reaction)
+	}
+	return reactions, rows.Err()
+}
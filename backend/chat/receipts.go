@@ -0,0 +1,169 @@
+package chat
+
+import (
+	"backend/db"
+	"log"
+	"time"
+)
+
+// HandleReceipt processes an inbound "delivered" or "read" frame. msg.ID is the newest
+// message the client has seen; everything up to it in that conversation is covered.
+func HandleReceipt(sender *Client, msg Message) {
+	if msg.ID <= 0 {
+		sendError(sender, msg, ErrInvalidRequest, "Receipt requires a message id")
+		return
+	}
+
+	// Group DMs keep a read marker but no per-message receipts
+	if msg.DMID > 0 {
+		if isDMParticipant(msg.SenderID, msg.DMID) {
+			advanced, err := advanceReadMarker(msg.SenderID, "group_dm", msg.DMID, msg.ID)
+			if err != nil {
+				log.Printf("Failed to store read marker for user %d: %v", msg.SenderID, err)
+			} else if advanced {
+				pushUnread(msg.SenderID, "group_dm", msg.DMID)
+			}
+		}
+		return
+	}
+
+	if msg.GroupID > 0 && !IsUserInGroup(msg.SenderID, msg.GroupID) {
+		log.Printf("User %d is not a member of group %d", msg.SenderID, msg.GroupID)
+		sendError(sender, msg, ErrForbidden, "Not a member of this group")
+		return
+	}
+
+	if err := applyReceipt(msg, sender); err != nil {
+		log.Printf("Failed to store %s receipt for user %d: %v", msg.Type, msg.SenderID, err)
+	}
+}
+
+// applyReceipt stores a receipt from msg.SenderID, tells the other side, and for reads
+// advances the read marker. except is the connection that reported it, if any. Receipts in a
+// private conversation still held by a message request are dropped, so they can't tell the
+// requester their message was seen.
+func applyReceipt(msg Message, except *Client) error {
+	if msg.GroupID == 0 && !canReach(msg.SenderID, msg.ReceiverID) {
+		return nil
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	var marked int64
+	var err error
+
+	if msg.GroupID > 0 {
+		marked, err = markGroupMessages(msg.SenderID, msg.GroupID, msg.ID, msg.Type, now)
+	} else {
+		marked, err = markPrivateMessages(msg.SenderID, msg.ReceiverID, msg.ID, msg.Type, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	// A repeated receipt marks nothing new; don't echo it around again
+	if marked > 0 {
+		// Report the newest message actually covered, not whatever id the client sent
+		receipt := Receipt{
+			Type:      "receipt",
+			Status:    msg.Type,
+			MessageID: latestCoveredMessageID(msg),
+			UserID:    msg.SenderID,
+			GroupID:   msg.GroupID,
+			At:        now,
+		}
+
+		if msg.GroupID > 0 {
+			broadcastToGroup(msg.GroupID, msg.SenderID, receipt)
+		} else {
+			receipt.ReceiverID = msg.ReceiverID
+			SendToUser(msg.ReceiverID, receipt)
+		}
+
+		// The reader's other devices can clear the conversation too
+		sendToUserExcept(msg.SenderID, except, receipt)
+	}
+
+	if msg.Type == "read" {
+		kind, conversationID := conversationOf(msg)
+		advanced, err := advanceReadMarker(msg.SenderID, kind, conversationID, msg.ID)
+		if err != nil {
+			return err
+		}
+		if advanced {
+			pushUnread(msg.SenderID, kind, conversationID)
+		}
+	}
+	return nil
+}
+
+// markPrivateMessages updates messages otherUserID sent to userID; reading implies delivery
+func markPrivateMessages(userID, otherUserID, upToID int, status, at string) (int64, error) {
+	query := `
+		UPDATE messages SET delivered_at = ?
+		WHERE sender_id = ? AND receiver_id = ? AND message_id <= ? AND delivered_at IS NULL`
+	args := []interface{}{at, otherUserID, userID, upToID}
+
+	if status == "read" {
+		query = `
+		UPDATE messages SET read_at = ?, delivered_at = COALESCE(delivered_at, ?)
+		WHERE sender_id = ? AND receiver_id = ? AND message_id <= ? AND read_at IS NULL`
+		args = []interface{}{at, at, otherUserID, userID, upToID}
+	}
+
+	result, err := db.Instance.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// markGroupMessages upserts the member's receipts for every group message up to upToID
+func markGroupMessages(userID, groupID, upToID int, status, at string) (int64, error) {
+	readAt := interface{}(nil)
+	if status == "read" {
+		readAt = at
+	}
+
+	result, err := db.Instance.Exec(`
+		INSERT INTO group_message_receipts (message_id, user_id, delivered_at, read_at)
+		SELECT gm.message_id, ?, ?, ?
+		FROM group_messages gm
+		LEFT JOIN group_message_receipts r ON r.message_id = gm.message_id AND r.user_id = ?
+		WHERE gm.group_id = ? AND gm.message_id <= ? AND gm.sender_id != ?
+		  AND (r.message_id IS NULL OR (? IS NOT NULL AND r.read_at IS NULL))
+		ON CONFLICT(message_id, user_id) DO UPDATE SET
+			read_at = COALESCE(group_message_receipts.read_at, excluded.read_at)`,
+		userID, at, readAt, userID, groupID, upToID, userID, readAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func latestCoveredMessageID(msg Message) int {
+	var lastID int
+	if msg.GroupID > 0 {
+		db.Instance.QueryRow(`
+			SELECT COALESCE(MAX(message_id), 0) FROM group_messages
+			WHERE group_id = ? AND sender_id != ? AND message_id <= ?`,
+			msg.GroupID, msg.SenderID, msg.ID).Scan(&lastID)
+	} else {
+		db.Instance.QueryRow(`
+			SELECT COALESCE(MAX(message_id), 0) FROM messages
+			WHERE sender_id = ? AND receiver_id = ? AND message_id <= ?`,
+			msg.ReceiverID, msg.SenderID, msg.ID).Scan(&lastID)
+	}
+	return lastID
+}
+
+// messageStatus maps the stored timestamps to the status reported in history
+func messageStatus(deliveredAt, readAt *string) string {
+	switch {
+	case readAt != nil:
+		return "read"
+	case deliveredAt != nil:
+		return "delivered"
+	default:
+		return "sent"
+	}
+}
@@ -0,0 +1,245 @@
+package chat
+
+import (
+	"backend/db"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	quoteSnippetLength = 120 // runes of the parent shown with a reply
+	maxThreadSize      = 500
+)
+
+var errInvalidReply = errors.New("reply target is not in this conversation")
+
+// QuotedMessage is the part of a parent message shown alongside a reply
+type QuotedMessage struct {
+	ID         int    `json:"id"`
+	SenderID   int    `json:"sender_id"`
+	SenderName string `json:"sender_name"`
+	Snippet    string `json:"snippet"`
+	Deleted    bool   `json:"deleted,omitempty"`
+}
+
+// resolveReply checks that msg.ReplyToID is a live message in the same conversation as msg
+// and returns its quote
+func resolveReply(kind string, msg Message) (*QuotedMessage, error) {
+	parent, err := loadStoredMessage(kind, msg.ReplyToID)
+	if err != nil {
+		return nil, errInvalidReply
+	}
+	if parent.Deleted {
+		return nil, errMessageDeleted
+	}
+
+	if kind == "group" {
+		if parent.GroupID != msg.GroupID {
+			return nil, errInvalidReply
+		}
+	} else if !(parent.SenderID == msg.SenderID && parent.ReceiverID == msg.ReceiverID ||
+		parent.SenderID == msg.ReceiverID && parent.ReceiverID == msg.SenderID) {
+		return nil, errInvalidReply
+	}
+
+	quotes, err := loadQuotes(kind, []int{parent.ID})
+	if err != nil {
+		return nil, err
+	}
+	return quotes[parent.ID], nil
+}
+
+// loadQuotes resolves the quotes for a set of parent message ids
+func loadQuotes(kind string, parentIDs []int) (map[int]*QuotedMessage, error) {
+	quotes := make(map[int]*QuotedMessage)
+	if len(parentIDs) == 0 {
+		return quotes, nil
+	}
+
+	args := make([]interface{}, len(parentIDs))
+	for i, id := range parentIDs {
+		args[i] = id
+	}
+
+	rows, err := db.Instance.Query(fmt.Sprintf(`
+		SELECT p.message_id, p.sender_id, u.nickname, COALESCE(p.content, ''), p.deleted_at IS NOT NULL
+		FROM %s p
+		JOIN users u ON p.sender_id = u.id
+		WHERE p.message_id IN (?`+strings.Repeat(",?", len(parentIDs)-1)+`)`, messageTable(kind)), args...)
+	if err != nil {
+		return quotes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var quote QuotedMessage
+		if err := rows.Scan(&quote.ID, &quote.SenderID, &quote.SenderName, &quote.Snippet, &quote.Deleted); err != nil {
+			return quotes, err
+		}
+		quote.Snippet = snippet(quote.Snippet)
+		quotes[quote.ID] = &quote
+	}
+	return quotes, rows.Err()
+}
+
+// attachQuotes fills ReplyTo on every message in a page that is a reply
+func attachQuotes(kind string, messages []Message) {
+	var parentIDs []int
+	for _, msg := range messages {
+		if msg.ReplyToID > 0 {
+			parentIDs = append(parentIDs, msg.ReplyToID)
+		}
+	}
+
+	quotes, err := loadQuotes(kind, parentIDs)
+	if err != nil {
+		log.Printf("Failed to load quoted messages: %v", err)
+	}
+	for i := range messages {
+		messages[i].ReplyTo = quotes[messages[i].ReplyToID]
+	}
+}
+
+func sendReplyError(sender *Client, msg Message, err error) {
+	text := "Cannot reply to that message"
+	if errors.Is(err, errMessageDeleted) {
+		text = "Cannot reply to a deleted message"
+	}
+	sendError(sender, msg, ErrInvalidRequest, text)
+}
+
+func snippet(content string) string {
+	runes := []rune(strings.TrimSpace(content))
+	if len(runes) <= quoteSnippetLength {
+		return string(runes)
+	}
+	return string(runes[:quoteSnippetLength]) + "…"
+}
+
+// nullableID stores 0 as NULL for optional foreign keys
+func nullableID(id int) interface{} {
+	if id <= 0 {
+		return nil
+	}
+	return id
+}
+
+// nullableString stores "" as NULL for optional text columns
+func nullableString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// GetThreadHandler returns the whole thread a message belongs to, root first:
+// GET /chat/thread?conversation_type=user|group&message_id=N
+func GetThreadHandler(w http.ResponseWriter, r *http.Request) {
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	kind := r.URL.Query().Get("conversation_type")
+	if kind != "user" && kind != "group" {
+		http.Error(w, "conversation_type must be user or group", http.StatusBadRequest)
+		return
+	}
+
+	stored, err := loadStoredMessage(kind, queryInt(r.URL.Query().Get("message_id")))
+	if err != nil || !canSeeMessage(stored, userID) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	rootID, messages, err := loadThread(kind, stored.ID)
+	if err != nil {
+		log.Printf("Failed to load thread of message %d: %v", stored.ID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"root_id":  rootID,
+		"messages": messages,
+	})
+}
+
+// loadThread walks up to the root of a message's thread, then returns the root
+// and every reply under it in id order
+func loadThread(kind string, messageID int) (int, []Message, error) {
+	table := messageTable(kind)
+
+	var rootID int
+	err := db.Instance.QueryRow(fmt.Sprintf(`
+		WITH RECURSIVE ancestors(id, parent_id) AS (
+			SELECT message_id, reply_to_id FROM %[1]s WHERE message_id = ?
+			UNION
+			SELECT t.message_id, t.reply_to_id FROM %[1]s t JOIN ancestors a ON t.message_id = a.parent_id
+		)
+		SELECT id FROM ancestors WHERE parent_id IS NULL`, table), messageID).Scan(&rootID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Private rows carry a receiver, group rows a group
+	conversation := "m.receiver_id, 0"
+	if kind == "group" {
+		conversation = "0, m.group_id"
+	}
+
+	rows, err := db.Instance.Query(fmt.Sprintf(`
+		WITH RECURSIVE thread(id) AS (
+			SELECT ?
+			UNION
+			SELECT t.message_id FROM %[1]s t JOIN thread ON t.reply_to_id = thread.id
+		)
+		SELECT m.message_id, m.sender_id, %[2]s, COALESCE(m.content, ''), m.created_at, u.nickname,
+		       COALESCE(m.edited_at, ''), m.deleted_at IS NOT NULL, COALESCE(m.reply_to_id, 0),
+		       COALESCE(m.media, ''), COALESCE(a.mime_type, '')
+		FROM %[1]s m
+		JOIN users u ON m.sender_id = u.id
+		LEFT JOIN chat_attachments a ON a.conversation_type = ? AND a.message_id = m.message_id
+		WHERE m.message_id IN (SELECT id FROM thread)
+		ORDER BY m.message_id ASC
+		LIMIT ?`, table, conversation), rootID, kind, maxThreadSize)
+	if err != nil {
+		return rootID, nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var msg Message
+		var createdAt time.Time
+		if err := rows.Scan(&msg.ID, &msg.SenderID, &msg.ReceiverID, &msg.GroupID, &msg.Content, &createdAt,
+			&msg.SenderName, &msg.EditedAt, &msg.Deleted, &msg.ReplyToID, &msg.Media, &msg.MediaType); err != nil {
+			return rootID, nil, err
+		}
+		msg.SentAt = createdAt.Format(time.RFC3339)
+		msg.Type = "private"
+		if kind == "group" {
+			msg.Type = "group"
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return rootID, nil, err
+	}
+
+	attachQuotes(kind, messages)
+	return rootID, messages, nil
+}
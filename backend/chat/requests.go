@@ -0,0 +1,282 @@
+package chat
+
+import (
+	"backend/db"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Message request statuses
+const (
+	RequestPending  = "pending"
+	RequestAccepted = "accepted"
+	RequestDeclined = "declined"
+	RequestBlocked  = "blocked"
+)
+
+var errNoRequest = errors.New("no such message request")
+
+// MessageRequest is a conversation opened by a message to someone the sender may not message
+// freely. Until the receiver accepts, its message stays out of their chat list, unread counts
+// and sync, and nothing more goes through. Sent live as "message_request" when it is opened
+// and when it is answered.
+type MessageRequest struct {
+	Type       string   `json:"type,omitempty"` // "message_request" on live events
+	SenderID   int      `json:"sender_id"`
+	ReceiverID int      `json:"receiver_id"`
+	SenderName string   `json:"sender_name,omitempty"`
+	Status     string   `json:"status"`            // "pending", "accepted", "declined" or "blocked"
+	Message    *Message `json:"message,omitempty"` // the opening message, for the receiver while pending
+	CreatedAt  string   `json:"created_at,omitempty"`
+}
+
+func (m MessageRequest) EventType() string { return m.Type }
+
+// privateAccess is how a private message from one user to another is handled
+type privateAccess int
+
+const (
+	accessDenied  privateAccess = iota // blocked, or the sender's request is unanswered or was declined
+	accessAllowed                      // may message freely
+	accessRequest                      // the message opens a request
+	accessReply                        // the receiver's request to the sender is pending; replying accepts it
+)
+
+// requestStatus is the status of the request from sender to receiver, "" if there is none
+func requestStatus(senderID, receiverID int) (string, error) {
+	var status string
+	err := db.Instance.QueryRow("SELECT status FROM message_requests WHERE sender_id = ? AND receiver_id = ?",
+		senderID, receiverID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return status, err
+}
+
+// privateMessageAccess decides how a message from senderID to receiverID is handled. A block
+// either way stops everything; an accepted request either way, or CanUsersMessage, lets it
+// through; anyone else gets to send one message, as a request.
+func privateMessageAccess(senderID, receiverID int) (privateAccess, error) {
+	outgoing, err := requestStatus(senderID, receiverID)
+	if err != nil {
+		return accessDenied, err
+	}
+	incoming, err := requestStatus(receiverID, senderID)
+	if err != nil {
+		return accessDenied, err
+	}
+
+	switch {
+	case outgoing == RequestBlocked || incoming == RequestBlocked:
+		return accessDenied, nil
+	case outgoing == RequestAccepted || incoming == RequestAccepted:
+		return accessAllowed, nil
+	case incoming == RequestPending:
+		return accessReply, nil
+	}
+
+	free, err := CanUsersMessage(senderID, receiverID)
+	if err != nil {
+		return accessDenied, err
+	}
+	if free {
+		return accessAllowed, nil
+	}
+	if outgoing == "" {
+		return accessRequest, nil
+	}
+	return accessDenied, nil
+}
+
+// canReach reports whether typing, reactions, receipts and edits from one user may reach another. They
+// need a conversation both sides are free to use: while a request is pending, declined or blocked
+// they would say more than the one message a request allows.
+func canReach(senderID, receiverID int) bool {
+	access, err := privateMessageAccess(senderID, receiverID)
+	if err != nil {
+		log.Printf("Error checking message permissions: %v", err)
+		return false
+	}
+	return access == accessAllowed
+}
+
+// canViewConversation reports whether a user may read their conversation with another:
+// anyone they may message or reply to, plus the receiver of a request they are waiting on.
+// searchPrivateMessages repeats this in SQL; keep the two in step.
+func canViewConversation(userID, otherID int) (bool, error) {
+	access, err := privateMessageAccess(userID, otherID)
+	if err != nil {
+		return false, err
+	}
+	if access != accessDenied {
+		return true, nil
+	}
+	outgoing, err := requestStatus(userID, otherID)
+	return outgoing == RequestPending, err
+}
+
+// openMessageRequest records a saved message as a new request. It fails if the sender already
+// has one open, which only happens when two first messages race.
+func openMessageRequest(msg Message) error {
+	result, err := db.Instance.Exec(`
+		INSERT INTO message_requests (sender_id, receiver_id, message_id, status, created_at)
+		VALUES (?, ?, ?, 'pending', ?)
+		ON CONFLICT(sender_id, receiver_id) DO NOTHING`,
+		msg.SenderID, msg.ReceiverID, msg.ID, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	if opened, err := result.RowsAffected(); err != nil || opened == 0 {
+		return errors.New("message request already open")
+	}
+
+	// The receiver hears of it as a request, not as a message
+	SendToUser(msg.ReceiverID, MessageRequest{
+		Type:       "message_request",
+		SenderID:   msg.SenderID,
+		ReceiverID: msg.ReceiverID,
+		SenderName: msg.SenderName,
+		Status:     RequestPending,
+		Message:    &msg,
+		CreatedAt:  msg.SentAt,
+	})
+	return nil
+}
+
+// answerMessageRequest is the receiver's answer to a request. Any answer can later be changed,
+// so a decline or block can be undone by accepting.
+func answerMessageRequest(senderID, receiverID int, status string) error {
+	result, err := db.Instance.Exec(`
+		UPDATE message_requests SET status = ?, responded_at = ?
+		WHERE sender_id = ? AND receiver_id = ?`,
+		status, time.Now().Format(time.RFC3339), senderID, receiverID)
+	if err != nil {
+		return err
+	}
+	if answered, err := result.RowsAffected(); err != nil || answered == 0 {
+		return errNoRequest
+	}
+	log.Printf("User %d %s the message request of user %d", receiverID, status, senderID)
+
+	// The sender only hears about acceptance; declines and blocks look like silence
+	event := MessageRequest{Type: "message_request", SenderID: senderID, ReceiverID: receiverID, Status: status}
+	SendToUser(receiverID, event)
+	if status == RequestAccepted {
+		SendToUser(senderID, event)
+		// The opening message now counts as unread
+		pushUnread(receiverID, "user", senderID)
+	}
+	return nil
+}
+
+// MessageRequestsHandler lists the caller's pending requests, newest first: GET /chat/requests
+func MessageRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := db.Instance.Query(`
+		SELECT mr.sender_id, u.nickname, mr.created_at,
+		       m.message_id, m.content, m.created_at, COALESCE(m.media, ''), m.deleted_at IS NOT NULL
+		FROM message_requests mr
+		JOIN users u ON u.id = mr.sender_id
+		JOIN messages m ON m.message_id = mr.message_id
+		WHERE mr.receiver_id = ? AND mr.status = 'pending'
+		ORDER BY mr.created_at DESC`, userID)
+	if err != nil {
+		log.Printf("Error loading message requests for user %d: %v", userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	requests := []MessageRequest{}
+	for rows.Next() {
+		request := MessageRequest{ReceiverID: userID, Status: RequestPending}
+		msg := Message{Type: "private", ReceiverID: userID}
+		var createdAt, sentAt time.Time
+		if err := rows.Scan(&request.SenderID, &request.SenderName, &createdAt,
+			&msg.ID, &msg.Content, &sentAt, &msg.Media, &msg.Deleted); err != nil {
+			log.Printf("Error scanning message request: %v", err)
+			continue
+		}
+		request.CreatedAt = createdAt.Format(time.RFC3339)
+		msg.SenderID, msg.SenderName = request.SenderID, request.SenderName
+		msg.SentAt = sentAt.Format(time.RFC3339)
+		request.Message = &msg
+		requests = append(requests, request)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"requests":    requests,
+		"total_count": len(requests),
+	})
+}
+
+// RespondToMessageRequestHandler answers a request: POST /chat/requests/respond
+// {sender_id, action: "accept", "decline" or "block"}
+func RespondToMessageRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		SenderID int    `json:"sender_id"`
+		Action   string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	statuses := map[string]string{"accept": RequestAccepted, "decline": RequestDeclined, "block": RequestBlocked}
+	status, ok := statuses[req.Action]
+	if !ok {
+		http.Error(w, "Action must be 'accept', 'decline' or 'block'", http.StatusBadRequest)
+		return
+	}
+
+	if err := answerMessageRequest(req.SenderID, userID, status); err != nil {
+		if err == errNoRequest {
+			http.Error(w, "Message request not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error answering message request for user %d: %v", userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MessageRequest{SenderID: req.SenderID, ReceiverID: userID, Status: status})
+}
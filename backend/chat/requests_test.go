@@ -0,0 +1,138 @@
+package chat
+
+import (
+	"backend/config"
+	"backend/db"
+	"backend/pkg/db/sqlite"
+	"path/filepath"
+	"testing"
+)
+
+// useTestDB points db.Instance at a fresh, fully migrated database for one test
+func useTestDB(t *testing.T) {
+	t.Helper()
+	cfg := config.DatabaseConfig{
+		Path:           filepath.Join(t.TempDir(), "forum.db"),
+		MigrationsPath: "../pkg/db/migrations/sqlite",
+	}
+	previous := db.Instance
+	db.InitDB(cfg.Path)
+	sqlite.ApplyMigrations(cfg)
+	t.Cleanup(func() {
+		db.Instance.Close()
+		db.Instance = previous
+	})
+}
+
+func addTestUser(t *testing.T, nickname, profileType string) int {
+	t.Helper()
+	result, err := db.Instance.Exec(`
+		INSERT INTO users (email, password, first_name, last_name, date_of_birth, nickname, profile_type)
+		VALUES (?, 'x', 'A', 'B', '2000-01-01', ?, ?)`, nickname+"@example.com", nickname, profileType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+func TestPrivateMessageAccess(t *testing.T) {
+	type direction struct {
+		access privateAccess
+		reach  bool
+		view   bool
+	}
+	tests := []struct {
+		name       string
+		alice, bob string // profile types
+		follow     string // status of alice following bob, if any
+		request    string // status of alice's message request to bob, if any
+		aliceToBob direction
+		bobToAlice direction
+	}{
+		{
+			name: "public profiles", alice: "public", bob: "public",
+			aliceToBob: direction{accessAllowed, true, true},
+			bobToAlice: direction{accessAllowed, true, true},
+		},
+		{
+			// Anyone may message a public profile, but not the other way round
+			name: "public receiver", alice: "private", bob: "public",
+			aliceToBob: direction{accessAllowed, true, true},
+			bobToAlice: direction{accessRequest, false, true},
+		},
+		{
+			name: "strangers", alice: "private", bob: "private",
+			aliceToBob: direction{accessRequest, false, true},
+			bobToAlice: direction{accessRequest, false, true},
+		},
+		{
+			name: "accepted follow", alice: "private", bob: "private", follow: "accepted",
+			aliceToBob: direction{accessAllowed, true, true},
+			bobToAlice: direction{accessAllowed, true, true},
+		},
+		{
+			name: "pending follow", alice: "private", bob: "private", follow: "pending",
+			aliceToBob: direction{accessRequest, false, true},
+			bobToAlice: direction{accessRequest, false, true},
+		},
+		{
+			// Alice has had her one message; Bob may answer it
+			name: "pending request", alice: "private", bob: "private", request: RequestPending,
+			aliceToBob: direction{accessDenied, false, true},
+			bobToAlice: direction{accessReply, false, true},
+		},
+		{
+			name: "accepted request", alice: "private", bob: "private", request: RequestAccepted,
+			aliceToBob: direction{accessAllowed, true, true},
+			bobToAlice: direction{accessAllowed, true, true},
+		},
+		{
+			name: "declined request", alice: "private", bob: "private", request: RequestDeclined,
+			aliceToBob: direction{accessDenied, false, false},
+			bobToAlice: direction{accessRequest, false, true},
+		},
+		{
+			name: "blocked request", alice: "public", bob: "public", follow: "accepted", request: RequestBlocked,
+			aliceToBob: direction{accessDenied, false, false},
+			bobToAlice: direction{accessDenied, false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDB(t)
+			alice := addTestUser(t, "alice", tt.alice)
+			bob := addTestUser(t, "bob", tt.bob)
+			if tt.follow != "" {
+				if _, err := db.Instance.Exec(`INSERT INTO followers (follower_id, following_id, status) VALUES (?, ?, ?)`,
+					alice, bob, tt.follow); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.request != "" {
+				if _, err := db.Instance.Exec(`INSERT INTO message_requests (sender_id, receiver_id, message_id, status) VALUES (?, ?, 1, ?)`,
+					alice, bob, tt.request); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			check := func(label string, from, to int, want direction) {
+				access, err := privateMessageAccess(from, to)
+				if err != nil {
+					t.Fatalf("%s: %v", label, err)
+				}
+				view, err := canViewConversation(from, to)
+				if err != nil {
+					t.Fatalf("%s: %v", label, err)
+				}
+				got := direction{access, canReach(from, to), view}
+				if got != want {
+					t.Errorf("%s: got %+v, want %+v", label, got, want)
+				}
+			}
+			check("alice to bob", alice, bob, tt.aliceToBob)
+			check("bob to alice", bob, alice, tt.bobToAlice)
+		})
+	}
+}
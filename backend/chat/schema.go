@@ -0,0 +1,117 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+// ProtocolSchemaHandler publishes the socket protocol as JSON Schema: GET /ws/schema.
+// It is generated from the event structs, so it can't drift from what the server sends.
+// "$defs" holds every payload; "inbound" and "outbound" map each envelope type to one of them.
+func ProtocolSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(protocolSchema())
+}
+
+func protocolSchema() map[string]interface{} {
+	defs := make(map[string]interface{})
+
+	inbound := make(map[string]interface{})
+	for eventType, newPayload := range inboundEvents {
+		inbound[eventType] = schemaOf(reflect.TypeOf(newPayload()), defs)
+	}
+	outbound := make(map[string]interface{})
+	for eventType, payload := range outboundEvents {
+		outbound[eventType] = schemaOf(reflect.TypeOf(payload), defs)
+	}
+
+	return map[string]interface{}{
+		"$schema":   "https://json-schema.org/draft/2020-12/schema",
+		"title":     "Realtime socket protocol",
+		"version":   ProtocolVersion,
+		"envelope":  schemaOf(reflect.TypeOf(Envelope{}), defs),
+		"handshake": map[string]interface{}{"auth": schemaOf(reflect.TypeOf(AuthFrame{}), defs)},
+		"inbound":   inbound,
+		"outbound":  outbound,
+		"errors": []string{
+			ErrInvalidFrame, ErrUnsupportedVersion, ErrUnknownType, ErrUnauthorized,
+			ErrInvalidRequest, ErrForbidden, ErrNotFound, ErrRateLimited, ErrInternal,
+		},
+		"$defs": defs,
+	}
+}
+
+// schemaOf describes a Go type the way encoding/json renders it; structs go into defs by name
+func schemaOf(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	switch {
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), defs)
+	case reflect.Struct:
+		name := t.Name()
+		if _, seen := defs[name]; !seen {
+			defs[name] = nil // placeholder, so recursive types terminate
+			defs[name] = structSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + name}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), defs)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default: // interface{}: any JSON value
+		return map[string]interface{}{}
+	}
+}
+
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaOf(field.Type, defs)
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+
+	sort.Strings(required)
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
@@ -0,0 +1,213 @@
+package chat
+
+import (
+	"backend/db"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+
+	// Snippet markers are control characters so the content can be HTML-escaped before they become <mark> tags
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// SearchAvailable is set at startup once the search index is in place; builds without FTS5 run without it
+var SearchAvailable bool
+
+// SearchHit is one matching message and where to find it
+type SearchHit struct {
+	ConversationType string `json:"conversation_type"` // "user" or "group"
+	ConversationID   int    `json:"conversation_id"`   // the other user or the group
+	MessageID        int    `json:"message_id"`
+	SenderID         int    `json:"sender_id"`
+	SenderName       string `json:"sender_name"`
+	SentAt           string `json:"sent_at"`
+	Snippet          string `json:"snippet"` // HTML-escaped, matches wrapped in <mark></mark>
+	Context          string `json:"context"` // history request that opens the conversation around the hit
+
+	at time.Time
+}
+
+// SearchMessagesHandler searches the caller's chat history, newest first:
+// GET /chat/search?q=...&conversation_type=user|group&conversation_id=N&limit=
+// Every term must match, as a word prefix. Hits only come from conversations the caller
+// can open right now, as decided by canViewConversation and accepted group membership.
+// Builds without FTS5 have no index and answer 503.
+func SearchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	if !SearchAvailable {
+		http.Error(w, "Message search is not available on this server", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	match := ftsQuery(query.Get("q"))
+	if match == "" {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+
+	kind := query.Get("conversation_type")
+	if kind != "" && kind != "user" && kind != "group" {
+		http.Error(w, "conversation_type must be user or group", http.StatusBadRequest)
+		return
+	}
+	conversationID := queryInt(query.Get("conversation_id"))
+
+	limit := queryInt(query.Get("limit"))
+	if limit <= 0 || limit > maxSearchLimit {
+		limit = defaultSearchLimit
+	}
+
+	var hits []SearchHit
+	if kind != "group" {
+		private, err := searchPrivateMessages(userID, match, conversationID, limit)
+		if err != nil {
+			log.Printf("Private message search failed for user %d: %v", userID, err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		hits = append(hits, private...)
+	}
+	if kind != "user" {
+		group, err := searchGroupMessages(userID, match, conversationID, limit)
+		if err != nil {
+			log.Printf("Group message search failed for user %d: %v", userID, err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		hits = append(hits, group...)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].at.After(hits[j].at)
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	if hits == nil {
+		hits = []SearchHit{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hits)
+}
+
+// ftsQuery turns free text into an FTS5 query: each word quoted (so operators in user input
+// are plain text) and prefix-matched, all words required
+func ftsQuery(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// searchPrivateMessages returns the caller's private matches newest first, only with users whose
+// conversation they may still open; withUserID narrows it to one conversation. The access
+// condition is canViewConversation, spelled out in SQL so the limit applies after it.
+func searchPrivateMessages(userID int, match string, withUserID, limit int) ([]SearchHit, error) {
+	rows, err := db.Instance.Query(`
+		SELECT m.message_id, m.sender_id, o.id, m.created_at, u.nickname,
+		       snippet(messages_fts, 0, ?, ?, '…', 16)
+		FROM messages_fts
+		JOIN messages m ON m.message_id = messages_fts.rowid
+		JOIN users u ON m.sender_id = u.id
+		JOIN users o ON o.id = CASE WHEN m.sender_id = ? THEN m.receiver_id ELSE m.sender_id END
+		LEFT JOIN message_requests mine ON mine.sender_id = ? AND mine.receiver_id = o.id
+		LEFT JOIN message_requests theirs ON theirs.sender_id = o.id AND theirs.receiver_id = ?
+		WHERE messages_fts MATCH ?
+		  AND (m.sender_id = ? OR m.receiver_id = ?)
+		  AND (? = 0 OR o.id = ?)
+		  AND m.deleted_at IS NULL
+		  AND (mine.status = 'pending'
+		       OR (COALESCE(mine.status, '') != 'blocked' AND COALESCE(theirs.status, '') != 'blocked'
+		           AND (mine.status IS NULL OR mine.status = 'accepted' OR theirs.status IN ('accepted', 'pending')
+		                OR o.profile_type = 'public'
+		                OR EXISTS (SELECT 1 FROM followers f
+		                           WHERE ((f.follower_id = ? AND f.following_id = o.id) OR (f.follower_id = o.id AND f.following_id = ?))
+		                             AND f.status = 'accepted'))))
+		ORDER BY m.message_id DESC
+		LIMIT ?`,
+		markStart, markEnd, userID, userID, userID, match, userID, userID, withUserID, withUserID, userID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var hit SearchHit
+		var other int
+		if err := rows.Scan(&hit.MessageID, &hit.SenderID, &other, &hit.at, &hit.SenderName, &hit.Snippet); err != nil {
+			return nil, err
+		}
+
+		hit.ConversationType = "user"
+		hit.ConversationID = other
+		hit.Context = fmt.Sprintf("/private-messages?other_user=%d&around_id=%d", other, hit.MessageID)
+		hits = append(hits, finishHit(hit))
+	}
+	return hits, rows.Err()
+}
+
+// searchGroupMessages returns group matches newest first, only in groups the caller belongs to
+func searchGroupMessages(userID int, match string, groupID, limit int) ([]SearchHit, error) {
+	rows, err := db.Instance.Query(`
+		SELECT gm.message_id, gm.group_id, gm.sender_id, gm.created_at, u.nickname,
+		       snippet(group_messages_fts, 0, ?, ?, '…', 16)
+		FROM group_messages_fts
+		JOIN group_messages gm ON gm.message_id = group_messages_fts.rowid
+		JOIN group_memberships gms ON gms.group_id = gm.group_id AND gms.user_id = ? AND gms.status = 'accepted'
+		JOIN users u ON gm.sender_id = u.id
+		WHERE group_messages_fts MATCH ?
+		  AND (? = 0 OR gm.group_id = ?)
+		  AND gm.deleted_at IS NULL
+		ORDER BY gm.message_id DESC
+		LIMIT ?`,
+		markStart, markEnd, userID, match, groupID, groupID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var hit SearchHit
+		if err := rows.Scan(&hit.MessageID, &hit.ConversationID, &hit.SenderID, &hit.at, &hit.SenderName, &hit.Snippet); err != nil {
+			return nil, err
+		}
+
+		hit.ConversationType = "group"
+		hit.Context = fmt.Sprintf("/group-messages?group_id=%d&around_id=%d", hit.ConversationID, hit.MessageID)
+		hits = append(hits, finishHit(hit))
+	}
+	return hits, rows.Err()
+}
+
+func finishHit(hit SearchHit) SearchHit {
+	hit.SentAt = hit.at.Format(time.RFC3339)
+	hit.Snippet = strings.NewReplacer(markStart, "<mark>", markEnd, "</mark>").Replace(html.EscapeString(hit.Snippet))
+	return hit
+}
@@ -0,0 +1,199 @@
+package chat
+
+import (
+	"backend/db"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// mutedForever is the muted_until of a conversation muted until further notice
+const mutedForever = "9999-12-31T23:59:59Z"
+
+// ConversationSettings is how one user has set up one conversation. Muted and archived
+// conversations don't count toward total_unread; pinned ones head the chat list.
+type ConversationSettings struct {
+	Type             string `json:"type"`              // "conversation_settings"
+	ConversationType string `json:"conversation_type"` // "user", "group" or "group_dm"
+	ConversationID   int    `json:"conversation_id"`
+	Muted            bool   `json:"muted"`
+	MutedUntil       string `json:"muted_until,omitempty"` // unset when muted until unmuted
+	Archived         bool   `json:"archived"`
+	Pinned           bool   `json:"pinned"`
+}
+
+func (s ConversationSettings) EventType() string { return s.Type }
+
+// now as stored in conversation_settings, so times compare as strings
+func settingsNow() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// settingsFrom fills in the muted flag and the settings' outward form from the stored columns
+func settingsFrom(kind string, conversationID int, mutedUntil string, archived bool, pinnedAt string) ConversationSettings {
+	settings := ConversationSettings{
+		Type:             "conversation_settings",
+		ConversationType: kind,
+		ConversationID:   conversationID,
+		Muted:            mutedUntil > settingsNow(),
+		Archived:         archived,
+		Pinned:           pinnedAt != "",
+	}
+	if settings.Muted && mutedUntil != mutedForever {
+		settings.MutedUntil = mutedUntil
+	}
+	return settings
+}
+
+// storedSettings reads a user's row for a conversation; zero values if there is none
+func storedSettings(userID int, kind string, conversationID int) (mutedUntil string, archived bool, pinnedAt string, err error) {
+	err = db.Instance.QueryRow(`
+		SELECT COALESCE(muted_until, ''), archived, COALESCE(pinned_at, '') FROM conversation_settings
+		WHERE user_id = ? AND conversation_type = ? AND conversation_id = ?`,
+		userID, kind, conversationID).Scan(&mutedUntil, &archived, &pinnedAt)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+// LoadConversationSettings returns a user's settings for a conversation; the defaults if there are none
+func LoadConversationSettings(userID int, kind string, conversationID int) (ConversationSettings, error) {
+	mutedUntil, archived, pinnedAt, err := storedSettings(userID, kind, conversationID)
+	if err != nil {
+		return ConversationSettings{}, err
+	}
+	return settingsFrom(kind, conversationID, mutedUntil, archived, pinnedAt), nil
+}
+
+// ConversationMuted reports whether a user has muted a conversation, so it shouldn't alert them
+func ConversationMuted(userID int, kind string, conversationID int) bool {
+	settings, err := LoadConversationSettings(userID, kind, conversationID)
+	if err != nil {
+		log.Printf("Error loading conversation settings for user %d: %v", userID, err)
+		return false
+	}
+	return settings.Muted
+}
+
+// ConversationSettingsHandler changes the caller's settings for one conversation:
+// POST /chat/settings {conversation_type, conversation_id, muted, muted_until, archived, pinned}.
+// Fields left out keep their value; muted_until (RFC 3339) only applies with "muted": true,
+// which without it mutes until unmuted. The new settings are returned and pushed to all the
+// caller's connections.
+func ConversationSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ConversationType string `json:"conversation_type"` // "user", "group" or "group_dm"
+		ConversationID   int    `json:"conversation_id"`
+		Muted            *bool  `json:"muted,omitempty"`
+		MutedUntil       string `json:"muted_until,omitempty"`
+		Archived         *bool  `json:"archived,omitempty"`
+		Pinned           *bool  `json:"pinned,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	switch req.ConversationType {
+	case "group":
+		if !IsUserInGroup(userID, req.ConversationID) {
+			http.Error(w, "You are not a member of this group", http.StatusForbidden)
+			return
+		}
+	case "group_dm":
+		if !isDMParticipant(userID, req.ConversationID) {
+			http.Error(w, "You are not in this conversation", http.StatusForbidden)
+			return
+		}
+	case "user":
+		var exists int
+		if err := db.Instance.QueryRow("SELECT 1 FROM users WHERE id = ?", req.ConversationID).Scan(&exists); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "conversation_type must be 'user', 'group' or 'group_dm'", http.StatusBadRequest)
+		return
+	}
+
+	// Fields left out keep their stored value
+	mutedUntil, archived, pinnedAt, err := storedSettings(userID, req.ConversationType, req.ConversationID)
+	if err != nil {
+		log.Printf("Error loading conversation settings for user %d: %v", userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if req.Muted != nil {
+		mutedUntil = ""
+		if *req.Muted {
+			mutedUntil = mutedForever
+			if req.MutedUntil != "" {
+				until, err := time.Parse(time.RFC3339, req.MutedUntil)
+				if err != nil || !until.After(time.Now()) {
+					http.Error(w, "muted_until must be a future RFC 3339 time", http.StatusBadRequest)
+					return
+				}
+				mutedUntil = until.UTC().Format(time.RFC3339)
+			}
+		}
+	}
+	if req.Archived != nil {
+		archived = *req.Archived
+	}
+	if req.Pinned != nil {
+		if !*req.Pinned {
+			pinnedAt = ""
+		} else if pinnedAt == "" {
+			pinnedAt = settingsNow()
+		}
+	}
+
+	_, err = db.Instance.Exec(`
+		INSERT INTO conversation_settings (user_id, conversation_type, conversation_id, muted_until, archived, pinned_at, updated_at)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), ?)
+		ON CONFLICT(user_id, conversation_type, conversation_id) DO UPDATE SET
+			muted_until = excluded.muted_until,
+			archived = excluded.archived,
+			pinned_at = excluded.pinned_at,
+			updated_at = excluded.updated_at`,
+		userID, req.ConversationType, req.ConversationID, mutedUntil, archived, pinnedAt, settingsNow())
+	if err != nil {
+		log.Printf("Error saving conversation settings for user %d: %v", userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	settings, err := LoadConversationSettings(userID, req.ConversationType, req.ConversationID)
+	if err != nil {
+		log.Printf("Error loading conversation settings for user %d: %v", userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Badges change with mute and archive, so the user's connections get the new totals too
+	SendToUser(userID, settings)
+	pushUnread(userID, req.ConversationType, req.ConversationID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
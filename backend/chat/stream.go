@@ -0,0 +1,336 @@
+package chat
+
+import (
+	"backend/db"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The event stream is the fallback for networks that block websocket upgrades.
+// GET /chat/stream carries every frame a socket would get as a server-sent event named after
+// the frame type, with the payload as data. POST /chat/stream/send takes the envelope a
+// version 2 socket would send and answers with the frames the socket would have replied
+// with (ack, error, sync). Both take the usual Authorization header, so browsers need a
+// fetch-based EventSource.
+//
+// Messages, notifications, edits, deletes and reactions carry the stream's sync cursor as their
+// event id; a stream opened with Last-Event-ID replays what was missed since, as "sync" would.
+
+// EventStreamHandler serves GET /chat/stream
+func EventStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := streamUser(w, r)
+	if !ok {
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	cursor, resuming := parseStreamCursor(lastEventID)
+	if !resuming {
+		cursor = CurrentCursor(userID)
+	} else if strings.Count(lastEventID, ".") < 4 {
+		// An id from before change replay: changes start from now rather than from the very first
+		cursor.ChangeID = latestChangeID(userID)
+	}
+
+	userGroups, err := GetUserGroups(userID)
+	if err != nil {
+		log.Printf("Error getting user groups: %v", err)
+		userGroups = []int{}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep reverse proxies from holding events back
+	w.WriteHeader(http.StatusOK)
+
+	stream := &eventStream{w: w, rc: http.NewResponseController(w), cursor: cursor, resuming: resuming}
+	client := newStreamClient(userID, userGroups)
+	client.AuthSession = r.Header.Get("User-Session")
+	log.Printf("User %d opened an event stream with groups: %v", userID, userGroups)
+	endSession := openSession(client, cursor)
+	defer endSession()
+
+	// Greet first, then replay; live frames queued before the replay finished that it covered are skipped
+	if err := stream.flushQueued(client); err != nil {
+		return
+	}
+	if resuming {
+		if err := stream.replay(userID); err != nil {
+			log.Printf("Event stream replay failed for user %d: %v", userID, err)
+			return
+		}
+		stream.resuming = false
+		stream.replayed = stream.cursor
+		stream.overlap = len(client.send)
+	}
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case data := <-client.send:
+			if err := stream.writeFrame(data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if client.endIfLoggedOut() {
+				continue
+			}
+			// A comment line keeps proxies from timing out an idle stream
+			if err := stream.write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+		case <-client.done:
+			// Flush what was queued before the close (a rate limit error, say)
+			stream.flushQueued(client)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// StreamSendHandler serves POST /chat/stream/send?session=, the inbound half of the event stream.
+// session is the stream's, from its "connected" event; the user's other connections get the
+// sender's copy of a message, but that stream doesn't.
+func StreamSendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := streamUser(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, "Frame too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Group frames are checked against the client's groups, loaded fresh as for a new connection
+	userGroups, err := GetUserGroups(userID)
+	if err != nil {
+		log.Printf("Error getting user groups: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// A client of its own, never registered, collects the replies for the response
+	client := newStreamClient(userID, userGroups)
+	if session := r.URL.Query().Get("session"); session != "" {
+		client.Session = session
+	}
+
+	msg, problem := decodeFrame(ProtocolVersion, data)
+	if problem != nil {
+		client.Send(*problem)
+	} else if allowFrame(client, msg) {
+		handleFrame(client, msg)
+	}
+
+	replies := []json.RawMessage{}
+	for pending := len(client.send); pending > 0; pending-- {
+		replies = append(replies, <-client.send)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(replies)
+}
+
+// streamUser resolves the authenticated user and turns away users banned by the rate limits
+func streamUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return 0, false
+	}
+
+	if wait := bannedFor(userID); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many messages, try again later", http.StatusTooManyRequests)
+		return 0, false
+	}
+	return userID, true
+}
+
+// eventStream writes frames as server-sent events and tracks the cursor they have reached.
+// Live frames queued before the replay finished may repeat it, so those it covered are skipped;
+// every later frame is written, whatever its id, as messages saved concurrently arrive out of order.
+type eventStream struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	cursor SyncCursor
+
+	resuming bool       // a replay is still to come, and will send every message frame queued so far
+	replayed SyncCursor // where the replay stopped
+	overlap  int        // frames still queued from before the replay finished
+}
+
+// position is the cursor stream an event type moves, nil for the ones that move none
+func (c *SyncCursor) position(eventType string) *int {
+	switch eventType {
+	case "private":
+		return &c.PrivateMessageID
+	case "group":
+		return &c.GroupMessageID
+	case "group_dm":
+		return &c.GroupDMMessageID
+	case "notification":
+		return &c.NotificationID
+	case "message_edited", "message_deleted", "reaction_added", "reaction_removed":
+		return &c.ChangeID
+	}
+	return nil
+}
+
+// The cursor as an event id: "private.group.notification.group_dm.change"
+func (c SyncCursor) eventID() string {
+	return fmt.Sprintf("%d.%d.%d.%d.%d", c.PrivateMessageID, c.GroupMessageID, c.NotificationID, c.GroupDMMessageID, c.ChangeID)
+}
+
+// parseStreamCursor also takes the shorter ids streams sent before group DMs and change replay
+func parseStreamCursor(id string) (SyncCursor, bool) {
+	var c SyncCursor
+	if id == "" {
+		return c, false
+	}
+	parts := strings.Split(id, ".")
+	if len(parts) < 3 || len(parts) > 5 {
+		return c, false
+	}
+	fields := []*int{&c.PrivateMessageID, &c.GroupMessageID, &c.NotificationID, &c.GroupDMMessageID, &c.ChangeID}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return SyncCursor{}, false
+		}
+		*fields[i] = n
+	}
+	return c, true
+}
+
+// replay sends everything after the cursor, page by page
+func (s *eventStream) replay(userID int) error {
+	for {
+		batch, err := Sync(userID, s.cursor, defaultSyncLimit)
+		if err != nil {
+			return err
+		}
+		for _, frame := range batch.Events {
+			event, ok := frame.(Event)
+			if !ok {
+				continue
+			}
+			payload, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if err := s.writeEvent(event.EventType(), payload, false); err != nil {
+				return err
+			}
+		}
+		// Streams that had nothing new still move on, so the next page starts after this one
+		s.cursor = batch.Next
+		if !batch.HasMore {
+			return nil
+		}
+	}
+}
+
+// flushQueued writes the frames already waiting, without blocking for more
+func (s *eventStream) flushQueued(client *Client) error {
+	for pending := len(client.send); pending > 0; pending-- {
+		if err := s.writeFrame(<-client.send); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFrame writes one queued frame, which as a version 2 client's is an envelope
+func (s *eventStream) writeFrame(data []byte) error {
+	if !s.resuming && s.overlap > 0 {
+		defer func() { s.overlap-- }()
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		log.Printf("Dropping malformed frame for event stream: %v", err)
+		return nil
+	}
+	return s.writeEvent(env.Type, env.Payload, true)
+}
+
+// replayCovers reports whether a live frame was, or is about to be, sent by the replay
+func (s *eventStream) replayCovers(eventType string, id int) bool {
+	switch {
+	case s.resuming:
+		return true
+	case s.overlap > 0:
+		return id <= *s.replayed.position(eventType)
+	}
+	return false
+}
+
+// writeEvent writes an event. Ones that move a sync stream carry the advanced cursor as their id;
+// live ones the replay covers are skipped. "connected" carries the cursor too, so a stream that
+// drops before anything arrives still resumes from the right place.
+func (s *eventStream) writeEvent(eventType string, payload []byte, live bool) error {
+	position := s.cursor.position(eventType)
+
+	withID := eventType == "connected"
+	if position != nil {
+		var ids struct {
+			ID           int `json:"id"`
+			ChangeID     int `json:"change_id"`
+			Notification struct {
+				ID int `json:"notification_id"`
+			} `json:"notification"`
+		}
+		json.Unmarshal(payload, &ids)
+		id := max(ids.ID, ids.Notification.ID)
+		if position == &s.cursor.ChangeID {
+			id = ids.ChangeID
+		}
+		if id > 0 {
+			if live && s.replayCovers(eventType, id) {
+				return nil
+			}
+			*position = max(*position, id)
+			withID = true
+		}
+	}
+
+	event := "event: " + eventType + "\n"
+	if withID {
+		event += "id: " + s.cursor.eventID() + "\n"
+	}
+	return s.write([]byte(event + "data: " + string(payload) + "\n\n"))
+}
+
+func (s *eventStream) write(event []byte) error {
+	s.rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := s.w.Write(event); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
@@ -0,0 +1,38 @@
+package chat
+
+import "testing"
+
+func TestParseStreamCursor(t *testing.T) {
+	tests := []struct {
+		id     string
+		want   SyncCursor
+		wantOK bool
+	}{
+		{"4.3.2.1.7", SyncCursor{PrivateMessageID: 4, GroupMessageID: 3, NotificationID: 2, GroupDMMessageID: 1, ChangeID: 7}, true},
+		{"0.0.0.0.0", SyncCursor{}, true},
+		// Ids from before group DMs, and from before change replay
+		{"4.3.2", SyncCursor{PrivateMessageID: 4, GroupMessageID: 3, NotificationID: 2}, true},
+		{"4.3.2.1", SyncCursor{PrivateMessageID: 4, GroupMessageID: 3, NotificationID: 2, GroupDMMessageID: 1}, true},
+		{"", SyncCursor{}, false},
+		{"4.3", SyncCursor{}, false},
+		{"4.3.2.1.7.6", SyncCursor{}, false},
+		{"4.x.2", SyncCursor{}, false},
+		{"4.-3.2", SyncCursor{}, false},
+		{"4..2", SyncCursor{}, false},
+		{"4.3.2.", SyncCursor{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseStreamCursor(tt.id)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("parseStreamCursor(%q) = %+v, %v; want %+v, %v", tt.id, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestStreamCursorRoundTrip(t *testing.T) {
+	c := SyncCursor{PrivateMessageID: 12, GroupMessageID: 5, NotificationID: 40, GroupDMMessageID: 3, ChangeID: 8}
+	got, ok := parseStreamCursor(c.eventID())
+	if !ok || got != c {
+		t.Errorf("parseStreamCursor(%q) = %+v, %v; want %+v", c.eventID(), got, ok, c)
+	}
+}
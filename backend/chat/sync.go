@@ -0,0 +1,280 @@
+package chat
+
+import (
+	"backend/db"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSyncLimit = 100
+	maxSyncLimit     = 500
+)
+
+// SyncCursor is the newest id a client has seen in each event stream.
+// Clients keep it up to date from live frames and from SyncBatch.Next; edit, delete and
+// reaction events move ChangeID by their change_id.
+type SyncCursor struct {
+	PrivateMessageID int `json:"private_message_id"`
+	GroupMessageID   int `json:"group_message_id"`
+	NotificationID   int `json:"notification_id"`
+	GroupDMMessageID int `json:"group_dm_message_id"`
+	ChangeID         int `json:"change_id"`
+}
+
+// SyncEvent is one missed item; Frame is shaped exactly like the live frame for it
+type SyncEvent struct {
+	Kind  string // "private", "group", "group_dm", "notification" or "change"
+	ID    int
+	At    time.Time
+	Frame interface{}
+}
+
+// SyncBatch is one page of missed events, oldest first
+type SyncBatch struct {
+	Type    string        `json:"type"` // "sync"
+	Events  []interface{} `json:"events"`
+	Next    SyncCursor    `json:"next"`
+	HasMore bool          `json:"has_more"`
+}
+
+// LoadNotificationsSince returns a user's notifications after an id. The notification
+// package owns that table and imports chat, so main wires it in.
+var LoadNotificationsSince func(userID, afterID, limit int) ([]SyncEvent, error)
+
+// Sync collects up to limit events of each kind after the cursor. Order is by time across
+// kinds and by id within a kind; Next advances each stream separately, so nothing is skipped
+// even when one stream fills its page before another.
+func Sync(userID int, since SyncCursor, limit int) (SyncBatch, error) {
+	if limit <= 0 || limit > maxSyncLimit {
+		limit = defaultSyncLimit
+	}
+
+	batch := SyncBatch{Type: "sync", Events: []interface{}{}, Next: since}
+
+	private, err := privateMessagesSince(userID, since.PrivateMessageID, limit)
+	if err != nil {
+		return batch, err
+	}
+	group, err := groupMessagesSince(userID, since.GroupMessageID, limit)
+	if err != nil {
+		return batch, err
+	}
+	groupDM, err := groupDMMessagesSince(userID, since.GroupDMMessageID, limit)
+	if err != nil {
+		return batch, err
+	}
+	changes, err := changesSince(userID, since.ChangeID, limit)
+	if err != nil {
+		return batch, err
+	}
+	var notifications []SyncEvent
+	if LoadNotificationsSince != nil {
+		if notifications, err = LoadNotificationsSince(userID, since.NotificationID, limit); err != nil {
+			return batch, err
+		}
+	}
+
+	batch.HasMore = len(private) == limit || len(group) == limit || len(groupDM) == limit ||
+		len(notifications) == limit || len(changes) == limit
+
+	events := append(append(append(append(private, group...), groupDM...), notifications...), changes...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].At.Before(events[j].At)
+	})
+
+	for _, event := range events {
+		batch.Events = append(batch.Events, event.Frame)
+		switch event.Kind {
+		case "private":
+			batch.Next.PrivateMessageID = max(batch.Next.PrivateMessageID, event.ID)
+		case "group":
+			batch.Next.GroupMessageID = max(batch.Next.GroupMessageID, event.ID)
+		case "group_dm":
+			batch.Next.GroupDMMessageID = max(batch.Next.GroupDMMessageID, event.ID)
+		case "notification":
+			batch.Next.NotificationID = max(batch.Next.NotificationID, event.ID)
+		case "change":
+			batch.Next.ChangeID = max(batch.Next.ChangeID, event.ID)
+		}
+	}
+	return batch, nil
+}
+
+// CurrentCursor is the position a freshly connected client starts from, taken over the user's
+// own conversations only, so it says nothing about activity elsewhere
+func CurrentCursor(userID int) SyncCursor {
+	var cursor SyncCursor
+	db.Instance.QueryRow(`SELECT COALESCE(MAX(message_id), 0) FROM messages WHERE sender_id = ? OR receiver_id = ?`,
+		userID, userID).Scan(&cursor.PrivateMessageID)
+	db.Instance.QueryRow(`
+		SELECT COALESCE(MAX(gm.message_id), 0) FROM group_messages gm
+		JOIN group_memberships gms ON gms.group_id = gm.group_id AND gms.user_id = ? AND gms.status = 'accepted'`,
+		userID).Scan(&cursor.GroupMessageID)
+	db.Instance.QueryRow(`SELECT COALESCE(MAX(notification_id), 0) FROM notifications WHERE user_id = ?`,
+		userID).Scan(&cursor.NotificationID)
+	db.Instance.QueryRow(`
+		SELECT COALESCE(MAX(m.message_id), 0) FROM group_dm_messages m
+		JOIN group_dm_participants p ON p.dm_id = m.dm_id AND p.user_id = ?`,
+		userID).Scan(&cursor.GroupDMMessageID)
+	cursor.ChangeID = latestChangeID(userID)
+	return cursor
+}
+
+// Includes the user's own messages so their other devices catch up too, but not message requests
+// sent to them until they accept
+func privateMessagesSince(userID, afterID, limit int) ([]SyncEvent, error) {
+	rows, err := db.Instance.Query(`
+		SELECT m.message_id, m.sender_id, m.receiver_id, m.content, m.created_at, u.nickname,
+		       COALESCE(m.edited_at, ''), m.deleted_at IS NOT NULL, COALESCE(m.reply_to_id, 0),
+		       COALESCE(m.media, ''), COALESCE(a.mime_type, '')
+		FROM messages m
+		JOIN users u ON m.sender_id = u.id
+		LEFT JOIN chat_attachments a ON a.conversation_type = 'user' AND a.message_id = m.message_id
+		WHERE (m.sender_id = ? OR m.receiver_id = ?) AND m.message_id > ?
+		  AND NOT (m.receiver_id = ? AND m.message_id IN (SELECT message_id FROM message_requests WHERE status != 'accepted'))
+		ORDER BY m.message_id ASC
+		LIMIT ?`, userID, userID, afterID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	var times []time.Time
+	for rows.Next() {
+		var msg Message
+		var createdAt time.Time
+		if err := rows.Scan(&msg.ID, &msg.SenderID, &msg.ReceiverID, &msg.Content, &createdAt, &msg.SenderName,
+			&msg.EditedAt, &msg.Deleted, &msg.ReplyToID, &msg.Media, &msg.MediaType); err != nil {
+			return nil, err
+		}
+		msg.SentAt = createdAt.Format(time.RFC3339)
+		msg.Type = "private"
+		messages = append(messages, msg)
+		times = append(times, createdAt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messageEvents("private", "user", messages, times), nil
+}
+
+// Only groups the user currently belongs to
+func groupMessagesSince(userID, afterID, limit int) ([]SyncEvent, error) {
+	rows, err := db.Instance.Query(`
+		SELECT gm.message_id, gm.group_id, gm.sender_id, gm.content, gm.created_at, u.nickname,
+		       COALESCE(gm.edited_at, ''), gm.deleted_at IS NOT NULL, COALESCE(gm.reply_to_id, 0),
+		       COALESCE(gm.media, ''), COALESCE(a.mime_type, '')
+		FROM group_messages gm
+		JOIN users u ON gm.sender_id = u.id
+		LEFT JOIN chat_attachments a ON a.conversation_type = 'group' AND a.message_id = gm.message_id
+		JOIN group_memberships gms ON gms.group_id = gm.group_id AND gms.user_id = ? AND gms.status = 'accepted'
+		WHERE gm.message_id > ?
+		ORDER BY gm.message_id ASC
+		LIMIT ?`, userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	var times []time.Time
+	for rows.Next() {
+		var msg Message
+		var createdAt time.Time
+		if err := rows.Scan(&msg.ID, &msg.GroupID, &msg.SenderID, &msg.Content, &createdAt, &msg.SenderName,
+			&msg.EditedAt, &msg.Deleted, &msg.ReplyToID, &msg.Media, &msg.MediaType); err != nil {
+			return nil, err
+		}
+		msg.SentAt = createdAt.Format(time.RFC3339)
+		msg.Type = "group"
+		messages = append(messages, msg)
+		times = append(times, createdAt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messageEvents("group", "group", messages, times), nil
+}
+
+// messageEvents resolves reply quotes for a page of messages and wraps them as events
+func messageEvents(eventKind, conversationType string, messages []Message, times []time.Time) []SyncEvent {
+	attachQuotes(conversationType, messages)
+
+	events := make([]SyncEvent, len(messages))
+	for i, msg := range messages {
+		events[i] = SyncEvent{Kind: eventKind, ID: msg.ID, At: times[i], Frame: msg}
+	}
+	return events
+}
+
+// HandleSync answers a {"type":"sync","since":{...}} frame with one SyncBatch.
+// Clients send another sync with batch.next while has_more is true.
+func HandleSync(sender *Client, msg Message) {
+	var since SyncCursor
+	if msg.Since != nil {
+		since = *msg.Since
+	}
+
+	batch, err := Sync(sender.ID, since, defaultSyncLimit)
+	if err != nil {
+		log.Printf("Sync failed for user %d: %v", sender.ID, err)
+		sendError(sender, msg, ErrInternal, "Sync failed")
+		return
+	}
+	sender.Send(batch)
+}
+
+// SyncHandler is the HTTP form of HandleSync:
+// GET /sync?since_private=&since_group=&since_group_dm=&since_notification=&since_change=&limit=
+func SyncHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	since := SyncCursor{
+		PrivateMessageID: queryInt(query.Get("since_private")),
+		GroupMessageID:   queryInt(query.Get("since_group")),
+		NotificationID:   queryInt(query.Get("since_notification")),
+		GroupDMMessageID: queryInt(query.Get("since_group_dm")),
+		ChangeID:         queryInt(query.Get("since_change")),
+	}
+
+	batch, err := Sync(userID, since, queryInt(query.Get("limit")))
+	if err != nil {
+		log.Printf("Sync failed for user %d: %v", userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
+
+// queryInt parses an optional non-negative query parameter, defaulting to 0
+func queryInt(value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
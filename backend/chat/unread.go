@@ -0,0 +1,257 @@
+package chat
+
+import (
+	"backend/db"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+// conversationOf maps a frame to the chat-list conversation of its sender:
+// ("group", group id), ("group_dm", dm id) or ("user", the other participant)
+func conversationOf(msg Message) (string, int) {
+	if msg.GroupID > 0 {
+		return "group", msg.GroupID
+	}
+	if msg.DMID > 0 {
+		return "group_dm", msg.DMID
+	}
+	return "user", msg.ReceiverID
+}
+
+// advanceReadMarker moves the user's last-read pointer forward (never back) to the newest
+// message of the conversation at or below upToID. Reports whether it moved.
+func advanceReadMarker(userID int, kind string, conversationID, upToID int) (bool, error) {
+	if kind == "group_dm" {
+		return advanceGroupDMReadMarker(userID, conversationID, upToID)
+	}
+
+	var lastID int
+	var err error
+	if kind == "group" {
+		err = db.Instance.QueryRow(`
+			SELECT COALESCE(MAX(message_id), 0) FROM group_messages
+			WHERE group_id = ? AND message_id <= ?`, conversationID, upToID).Scan(&lastID)
+	} else {
+		err = db.Instance.QueryRow(`
+			SELECT COALESCE(MAX(message_id), 0) FROM messages
+			WHERE ((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)) AND message_id <= ?`,
+			userID, conversationID, conversationID, userID, upToID).Scan(&lastID)
+	}
+	if err != nil || lastID == 0 {
+		return false, err
+	}
+
+	result, err := db.Instance.Exec(`
+		INSERT INTO conversation_reads (user_id, conversation_type, conversation_id, last_read_message_id, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, conversation_type, conversation_id) DO UPDATE SET
+			last_read_message_id = excluded.last_read_message_id,
+			updated_at = excluded.updated_at
+		WHERE excluded.last_read_message_id > conversation_reads.last_read_message_id`,
+		userID, kind, conversationID, lastID, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+// Group DM read markers live on the participant row, which goes when they leave
+func advanceGroupDMReadMarker(userID, dmID, upToID int) (bool, error) {
+	var lastID int
+	if err := db.Instance.QueryRow(`
+		SELECT COALESCE(MAX(message_id), 0) FROM group_dm_messages
+		WHERE dm_id = ? AND message_id <= ?`, dmID, upToID).Scan(&lastID); err != nil || lastID == 0 {
+		return false, err
+	}
+
+	result, err := db.Instance.Exec(`
+		UPDATE group_dm_participants SET last_read_message_id = ?
+		WHERE dm_id = ? AND user_id = ? AND last_read_message_id < ?`,
+		lastID, dmID, userID, lastID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+// UnreadCount counts messages from others after the user's read marker; a message request
+// only counts once accepted
+func UnreadCount(userID int, kind string, conversationID int) (int, error) {
+	var count int
+	var err error
+	switch kind {
+	case "group_dm":
+		err = db.Instance.QueryRow(`
+			SELECT COUNT(*) FROM group_dm_messages m
+			JOIN group_dm_participants p ON p.dm_id = m.dm_id AND p.user_id = ?
+			WHERE m.dm_id = ? AND m.sender_id != ? AND m.message_id > MAX(p.last_read_message_id, p.visible_after)`,
+			userID, conversationID, userID).Scan(&count)
+	case "group":
+		err = db.Instance.QueryRow(`
+			SELECT COUNT(*) FROM group_messages gm
+			WHERE gm.group_id = ? AND gm.sender_id != ?
+			  AND gm.message_id > COALESCE((SELECT last_read_message_id FROM conversation_reads
+			      WHERE user_id = ? AND conversation_type = 'group' AND conversation_id = gm.group_id), 0)`,
+			conversationID, userID, userID).Scan(&count)
+	default:
+		err = db.Instance.QueryRow(`
+			SELECT COUNT(*) FROM messages m
+			WHERE m.sender_id = ? AND m.receiver_id = ?
+			  AND m.message_id > COALESCE((SELECT last_read_message_id FROM conversation_reads
+			      WHERE user_id = ? AND conversation_type = 'user' AND conversation_id = m.sender_id), 0)
+			  AND m.message_id NOT IN (SELECT message_id FROM message_requests WHERE status != 'accepted')`,
+			conversationID, userID, userID).Scan(&count)
+	}
+	return count, err
+}
+
+// TotalUnread sums unread messages over every private conversation, group and group DM the user
+// is in, except the ones they muted or archived
+func TotalUnread(userID int) (int, error) {
+	now := settingsNow()
+	var total int
+	err := db.Instance.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM messages m
+			 WHERE m.receiver_id = ?
+			   AND m.message_id > COALESCE((SELECT last_read_message_id FROM conversation_reads
+			       WHERE user_id = ? AND conversation_type = 'user' AND conversation_id = m.sender_id), 0)
+			   AND m.message_id NOT IN (SELECT message_id FROM message_requests WHERE status != 'accepted')
+			   AND NOT EXISTS (SELECT 1 FROM conversation_settings cs
+			       WHERE cs.user_id = ? AND cs.conversation_type = 'user' AND cs.conversation_id = m.sender_id
+			         AND (cs.archived = 1 OR cs.muted_until > ?)))
+			+
+			(SELECT COUNT(*) FROM group_messages gm
+			 JOIN group_memberships gms ON gms.group_id = gm.group_id AND gms.user_id = ? AND gms.status = 'accepted'
+			 WHERE gm.sender_id != ?
+			   AND gm.message_id > COALESCE((SELECT last_read_message_id FROM conversation_reads
+			       WHERE user_id = ? AND conversation_type = 'group' AND conversation_id = gm.group_id), 0)
+			   AND NOT EXISTS (SELECT 1 FROM conversation_settings cs
+			       WHERE cs.user_id = ? AND cs.conversation_type = 'group' AND cs.conversation_id = gm.group_id
+			         AND (cs.archived = 1 OR cs.muted_until > ?)))
+			+
+			(SELECT COUNT(*) FROM group_dm_messages dm
+			 JOIN group_dm_participants p ON p.dm_id = dm.dm_id AND p.user_id = ?
+			 WHERE dm.sender_id != ?
+			   AND dm.message_id > MAX(p.last_read_message_id, p.visible_after)
+			   AND NOT EXISTS (SELECT 1 FROM conversation_settings cs
+			       WHERE cs.user_id = ? AND cs.conversation_type = 'group_dm' AND cs.conversation_id = dm.dm_id
+			         AND (cs.archived = 1 OR cs.muted_until > ?)))`,
+		userID, userID, userID, now, userID, userID, userID, userID, now, userID, userID, userID, now).Scan(&total)
+	return total, err
+}
+
+// pushUnread sends the user's new unread count for a conversation to all their connections
+func pushUnread(userID int, kind string, conversationID int) {
+	// Counting costs queries; skip it for users with nobody listening
+	if !IsUserOnline(userID) {
+		return
+	}
+
+	count, err := UnreadCount(userID, kind, conversationID)
+	if err != nil {
+		log.Printf("Error counting unread messages for user %d: %v", userID, err)
+		return
+	}
+	total, err := TotalUnread(userID)
+	if err != nil {
+		log.Printf("Error counting total unread for user %d: %v", userID, err)
+		return
+	}
+
+	SendToUser(userID, UnreadUpdate{
+		Type:             "unread",
+		ConversationType: kind,
+		ConversationID:   conversationID,
+		UnreadCount:      count,
+		TotalUnread:      total,
+		Muted:            ConversationMuted(userID, kind, conversationID),
+	})
+}
+
+// MarkConversationReadHandler advances the caller's read marker for one conversation.
+// message_id defaults to the newest message, i.e. "mark all as read".
+func MarkConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ConversationType string `json:"conversation_type"` // "user", "group" or "group_dm"
+		ConversationID   int    `json:"conversation_id"`
+		MessageID        int    `json:"message_id,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	msg := Message{Type: "read", SenderID: userID, ID: req.MessageID}
+	switch req.ConversationType {
+	case "group":
+		if !IsUserInGroup(userID, req.ConversationID) {
+			http.Error(w, "You are not a member of this group", http.StatusForbidden)
+			return
+		}
+		msg.GroupID = req.ConversationID
+	case "group_dm":
+		if !isDMParticipant(userID, req.ConversationID) {
+			http.Error(w, "You are not in this conversation", http.StatusForbidden)
+			return
+		}
+		msg.DMID = req.ConversationID
+	case "user":
+		msg.ReceiverID = req.ConversationID
+	default:
+		http.Error(w, "conversation_type must be 'user', 'group' or 'group_dm'", http.StatusBadRequest)
+		return
+	}
+	if msg.ID <= 0 {
+		// Marks everything; the markers only ever record ids that exist
+		msg.ID = math.MaxInt
+	}
+
+	var err error
+	if msg.DMID > 0 {
+		var advanced bool
+		if advanced, err = advanceReadMarker(userID, "group_dm", msg.DMID, msg.ID); advanced {
+			pushUnread(userID, "group_dm", msg.DMID)
+		}
+	} else {
+		err = applyReceipt(msg, nil)
+	}
+	if err != nil {
+		log.Printf("Error marking conversation read for user %d: %v", userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	count, _ := UnreadCount(userID, req.ConversationType, req.ConversationID)
+	total, _ := TotalUnread(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"conversation_type": req.ConversationType,
+		"conversation_id":   req.ConversationID,
+		"unread_count":      count,
+		"total_unread":      total,
+	})
+}
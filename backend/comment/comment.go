@@ -27,12 +27,13 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := user.ExtractUserIDFromToken(token)
+	claims, err := user.AuthenticateToken(token)
 	if err != nil {
 		log.Println("[createCommentHandler] Token extraction failed:", err)
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	userID := claims.UserID
 	log.Println("[createCommentHandler] User ID from token:", userID)
 
 	// Get post_id from query
@@ -0,0 +1,311 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config is the complete backend configuration.
+// Defaults come from Default, then the optional config file, then environment variables (see Load).
+type Config struct {
+	Server    ServerConfig    `json:"server"`
+	Database  DatabaseConfig  `json:"database"`
+	Uploads   UploadsConfig   `json:"uploads"`
+	Auth      AuthConfig      `json:"auth"`
+	WebSocket WebSocketConfig `json:"websocket"`
+	Chat      ChatConfig      `json:"chat"`
+	Hub       HubConfig       `json:"hub"`
+	RateLimit RateLimitConfig `json:"rate_limit"`
+}
+
+type ServerConfig struct {
+	Port           int      `json:"port"`
+	AllowedOrigins []string `json:"allowed_origins"` // "*" allows any origin
+}
+
+type DatabaseConfig struct {
+	Path           string `json:"path"`
+	MigrationsPath string `json:"migrations_path"`
+}
+
+type UploadsConfig struct {
+	Dir       string `json:"dir"`
+	AvatarDir string `json:"avatar_dir"` // defaults to <dir>/avatars
+
+	ChatDir             string   `json:"chat_dir"`              // chat attachments; defaults to <dir>/chat
+	MaxAttachmentSize   int64    `json:"max_attachment_size"`   // bytes
+	AttachmentMIMETypes []string `json:"attachment_mime_types"` // sniffed from the content, not the file name
+	AttachmentTTL       Duration `json:"attachment_ttl"`        // uploads no message has claimed are deleted after this long
+	PendingQuota        int64    `json:"pending_quota"`         // bytes a user may hold in unclaimed uploads
+}
+
+type AuthConfig struct {
+	// Signing keys: either a key file, or a single secret plus older keys still accepted for verification
+	KeyFile      string   `json:"key_file"`
+	Secret       string   `json:"secret"`
+	KeyID        string   `json:"kid"`
+	PreviousKeys []string `json:"previous_keys"` // "kid:secret" pairs
+
+	Issuer          string   `json:"issuer"`
+	Audience        string   `json:"audience"`
+	AccessTokenTTL  Duration `json:"access_token_ttl"`
+	RefreshTokenTTL Duration `json:"refresh_token_ttl"`
+}
+
+type WebSocketConfig struct {
+	SendBufferSize int      `json:"send_buffer_size"` // queued outbound frames per connection
+	WriteTimeout   Duration `json:"write_timeout"`    // deadline for a single frame write
+	PingInterval   Duration `json:"ping_interval"`    // how often the server pings each connection
+	PongTimeout    Duration `json:"pong_timeout"`     // connection is reaped if nothing is read for this long
+	MaxMessageSize int64    `json:"max_message_size"` // largest inbound frame in bytes
+}
+
+type ChatConfig struct {
+	EditWindow         Duration `json:"edit_window"`           // how long a sender may edit or delete a message
+	HistoryPageSize    int      `json:"history_page_size"`     // messages per history page when no limit is given
+	MaxHistoryPageSize int      `json:"max_history_page_size"` // upper bound on a requested limit
+	MaxContentLength   int      `json:"max_content_length"`    // characters in a message or an edit
+	MaxGroupDMSize     int      `json:"max_group_dm_size"`     // participants in a group DM, its creator included
+}
+
+// HubConfig selects how realtime frames and presence reach other backend instances
+type HubConfig struct {
+	Driver   string `json:"driver"`   // "memory" for a single instance, "redis" to share users across replicas
+	Address  string `json:"address"`  // redis host:port
+	Password string `json:"password"` // optional redis AUTH password
+	Prefix   string `json:"prefix"`   // key and channel prefix, so several deployments can share one redis
+}
+
+// RateLimitConfig bounds what one user may send over their sockets, per backend instance
+type RateLimitConfig struct {
+	MessagesPerSecond float64  `json:"messages_per_second"` // sustained rate of messages, edits, reactions and receipts
+	MessageBurst      int      `json:"message_burst"`
+	TypingPerSecond   float64  `json:"typing_per_second"`
+	TypingBurst       int      `json:"typing_burst"`
+	MaxViolations     int      `json:"max_violations"`   // refused frames within violation_window before a disconnect
+	ViolationWindow   Duration `json:"violation_window"` // how long refused frames are remembered
+	BanDuration       Duration `json:"ban_duration"`     // how long a disconnected user is turned away
+}
+
+// Duration lets durations be written as "15m" or "720h" in the config file
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"15m\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Default returns the settings the server used before it was configurable
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:           8088,
+			AllowedOrigins: []string{"*"},
+		},
+		Database: DatabaseConfig{
+			Path:           "./forum.db",
+			MigrationsPath: "pkg/db/migrations/sqlite",
+		},
+		Uploads: UploadsConfig{
+			Dir:               "uploads",
+			MaxAttachmentSize: 10 << 20,
+			AttachmentMIMETypes: []string{
+				"image/jpeg", "image/png", "image/gif", "image/webp",
+				"video/mp4", "video/webm", "audio/mpeg", "audio/wave", "application/pdf",
+			},
+			AttachmentTTL: Duration{24 * time.Hour},
+			PendingQuota:  50 << 20,
+		},
+		Auth: AuthConfig{
+			KeyID:           "default",
+			Issuer:          "real-time-forum",
+			Audience:        "real-time-forum-api",
+			AccessTokenTTL:  Duration{24 * time.Hour}, // long until the web client refreshes tokens
+			RefreshTokenTTL: Duration{30 * 24 * time.Hour},
+		},
+		WebSocket: WebSocketConfig{
+			SendBufferSize: 256,
+			WriteTimeout:   Duration{10 * time.Second},
+			PingInterval:   Duration{30 * time.Second},
+			PongTimeout:    Duration{60 * time.Second},
+			MaxMessageSize: 32 << 10,
+		},
+		Chat: ChatConfig{
+			EditWindow:         Duration{15 * time.Minute},
+			HistoryPageSize:    20,
+			MaxHistoryPageSize: 100,
+			MaxContentLength:   4000,
+			MaxGroupDMSize:     32,
+		},
+		Hub: HubConfig{
+			Driver: "memory",
+			Prefix: "forum:",
+		},
+		RateLimit: RateLimitConfig{
+			MessagesPerSecond: 5,
+			MessageBurst:      20,
+			TypingPerSecond:   4,
+			TypingBurst:       8,
+			MaxViolations:     10,
+			ViolationWindow:   Duration{time.Minute},
+			BanDuration:       Duration{5 * time.Minute},
+		},
+	}
+}
+
+// Validate fills in derived values and reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port %d out of range", c.Server.Port))
+	}
+	if len(c.Server.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("server.allowed_origins must not be empty"))
+	}
+
+	if c.Database.Path == "" {
+		errs = append(errs, errors.New("database.path is required"))
+	}
+	if c.Database.MigrationsPath == "" {
+		errs = append(errs, errors.New("database.migrations_path is required"))
+	}
+
+	if c.Uploads.Dir == "" {
+		errs = append(errs, errors.New("uploads.dir is required"))
+	}
+	if c.Uploads.AvatarDir == "" {
+		c.Uploads.AvatarDir = filepath.Join(c.Uploads.Dir, "avatars")
+	}
+	if c.Uploads.ChatDir == "" {
+		c.Uploads.ChatDir = filepath.Join(c.Uploads.Dir, "chat")
+	}
+	// /uploads serves uploads.dir alone; files stored anywhere else could never be fetched
+	if c.Uploads.Dir != "" {
+		if !within(c.Uploads.Dir, c.Uploads.AvatarDir) {
+			errs = append(errs, fmt.Errorf("uploads.avatar_dir %s is not inside uploads.dir %s", c.Uploads.AvatarDir, c.Uploads.Dir))
+		}
+		if !within(c.Uploads.Dir, c.Uploads.ChatDir) {
+			errs = append(errs, fmt.Errorf("uploads.chat_dir %s is not inside uploads.dir %s", c.Uploads.ChatDir, c.Uploads.Dir))
+		}
+	}
+	if c.Uploads.MaxAttachmentSize < 1 {
+		errs = append(errs, errors.New("uploads.max_attachment_size must be positive"))
+	}
+	if len(c.Uploads.AttachmentMIMETypes) == 0 {
+		errs = append(errs, errors.New("uploads.attachment_mime_types must not be empty"))
+	}
+	if c.Uploads.AttachmentTTL.Duration < time.Minute {
+		errs = append(errs, errors.New("uploads.attachment_ttl must be at least a minute"))
+	}
+	if c.Uploads.PendingQuota < c.Uploads.MaxAttachmentSize {
+		errs = append(errs, errors.New("uploads.pending_quota must be at least uploads.max_attachment_size"))
+	}
+
+	if c.Auth.Issuer == "" || c.Auth.Audience == "" {
+		errs = append(errs, errors.New("auth.issuer and auth.audience are required"))
+	}
+	if c.Auth.AccessTokenTTL.Duration <= 0 || c.Auth.RefreshTokenTTL.Duration <= 0 {
+		errs = append(errs, errors.New("auth token TTLs must be positive"))
+	} else if c.Auth.AccessTokenTTL.Duration >= c.Auth.RefreshTokenTTL.Duration {
+		errs = append(errs, errors.New("auth.access_token_ttl must be shorter than auth.refresh_token_ttl"))
+	}
+	if c.Auth.Secret != "" && c.Auth.KeyID == "" {
+		errs = append(errs, errors.New("auth.kid is required when auth.secret is set"))
+	}
+
+	if c.WebSocket.SendBufferSize < 1 {
+		errs = append(errs, errors.New("websocket.send_buffer_size must be at least 1"))
+	}
+	if c.WebSocket.WriteTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("websocket.write_timeout must be positive"))
+	}
+	if c.WebSocket.PingInterval.Duration <= 0 || c.WebSocket.PingInterval.Duration >= c.WebSocket.PongTimeout.Duration {
+		errs = append(errs, errors.New("websocket.ping_interval must be positive and shorter than websocket.pong_timeout"))
+	}
+	if c.WebSocket.MaxMessageSize < 512 {
+		errs = append(errs, errors.New("websocket.max_message_size must be at least 512 bytes"))
+	}
+
+	if c.Chat.EditWindow.Duration < 0 {
+		errs = append(errs, errors.New("chat.edit_window must not be negative"))
+	}
+	if c.Chat.HistoryPageSize < 1 || c.Chat.HistoryPageSize > c.Chat.MaxHistoryPageSize {
+		errs = append(errs, errors.New("chat.history_page_size must be between 1 and chat.max_history_page_size"))
+	}
+	if c.Chat.MaxContentLength < 1 {
+		errs = append(errs, errors.New("chat.max_content_length must be positive"))
+	}
+	if c.Chat.MaxGroupDMSize < 3 {
+		errs = append(errs, errors.New("chat.max_group_dm_size must be at least 3"))
+	}
+
+	switch c.Hub.Driver {
+	case "memory":
+	case "redis":
+		if c.Hub.Address == "" {
+			errs = append(errs, errors.New("hub.address is required for the redis driver"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("hub.driver %q must be memory or redis", c.Hub.Driver))
+	}
+
+	if c.RateLimit.MessagesPerSecond <= 0 || c.RateLimit.TypingPerSecond <= 0 {
+		errs = append(errs, errors.New("rate_limit rates must be positive"))
+	}
+	if c.RateLimit.MessageBurst < 1 || c.RateLimit.TypingBurst < 1 {
+		errs = append(errs, errors.New("rate_limit bursts must be at least 1"))
+	}
+	if c.RateLimit.MaxViolations < 1 || c.RateLimit.ViolationWindow.Duration <= 0 || c.RateLimit.BanDuration.Duration < 0 {
+		errs = append(errs, errors.New("rate_limit.max_violations and rate_limit.violation_window must be positive, rate_limit.ban_duration not negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// within reports whether dir is root or somewhere below it
+func within(root, dir string) bool {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absRoot, absDir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Addr is the listen address for http.ListenAndServe
+func (s ServerConfig) Addr() string {
+	return fmt.Sprintf(":%d", s.Port)
+}
+
+// AllowsOrigin reports whether a browser origin may call the API or open a WebSocket
+func (s ServerConfig) AllowsOrigin(origin string) bool {
+	for _, allowed := range s.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateUploadDirs(t *testing.T) {
+	tests := []struct {
+		name           string
+		dir, avatar, c string
+		wantErr        string
+	}{
+		{"defaults", "uploads", "", "", ""},
+		{"nested", "uploads", "uploads/avatars", "./uploads/media/chat", ""},
+		{"the root itself", "/srv/u", "/srv/u", "/srv/u/c", ""},
+		{"avatars outside", "uploads", "/tmp/avatars", "", "uploads.avatar_dir"},
+		{"climbs out", "uploads", "", "uploads/../chat", "uploads.chat_dir"},
+		{"shares a prefix only", "uploads", "", "uploads-chat", "uploads.chat_dir"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Uploads.Dir, cfg.Uploads.AvatarDir, cfg.Uploads.ChatDir = tt.dir, tt.avatar, tt.c
+			err := cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
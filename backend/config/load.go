@@ -0,0 +1,228 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Load builds the configuration from defaults, the optional JSON config file at path
+// (falling back to $CONFIG_FILE), and environment variables, in that order of precedence.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func applyEnv(cfg *Config) error {
+	if v := os.Getenv("PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("PORT: %w", err)
+		}
+		cfg.Server.Port = port
+	}
+	if v := os.Getenv("CORS_ALLOWED_ORIGINS"); v != "" {
+		cfg.Server.AllowedOrigins = splitList(v)
+	}
+
+	setString(&cfg.Database.Path, "DB_PATH")
+	setString(&cfg.Database.MigrationsPath, "MIGRATIONS_PATH")
+
+	setString(&cfg.Uploads.Dir, "UPLOAD_DIR")
+	setString(&cfg.Uploads.AvatarDir, "AVATAR_DIR")
+	setString(&cfg.Uploads.ChatDir, "CHAT_UPLOAD_DIR")
+	if v := os.Getenv("MAX_ATTACHMENT_SIZE"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("MAX_ATTACHMENT_SIZE: %w", err)
+		}
+		cfg.Uploads.MaxAttachmentSize = size
+	}
+	if v := os.Getenv("ATTACHMENT_MIME_TYPES"); v != "" {
+		cfg.Uploads.AttachmentMIMETypes = splitList(v)
+	}
+	if err := setDuration(&cfg.Uploads.AttachmentTTL, "ATTACHMENT_TTL"); err != nil {
+		return err
+	}
+	if v := os.Getenv("PENDING_ATTACHMENT_QUOTA"); v != "" {
+		quota, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("PENDING_ATTACHMENT_QUOTA: %w", err)
+		}
+		cfg.Uploads.PendingQuota = quota
+	}
+
+	setString(&cfg.Auth.KeyFile, "JWT_KEY_FILE")
+	setString(&cfg.Auth.Secret, "JWT_SECRET")
+	setString(&cfg.Auth.KeyID, "JWT_KID")
+	if v := os.Getenv("JWT_PREVIOUS_KEYS"); v != "" {
+		cfg.Auth.PreviousKeys = splitList(v)
+	}
+	setString(&cfg.Auth.Issuer, "JWT_ISSUER")
+	setString(&cfg.Auth.Audience, "JWT_AUDIENCE")
+	if err := setDuration(&cfg.Auth.AccessTokenTTL, "ACCESS_TOKEN_TTL"); err != nil {
+		return err
+	}
+	if err := setDuration(&cfg.Auth.RefreshTokenTTL, "REFRESH_TOKEN_TTL"); err != nil {
+		return err
+	}
+
+	if v := os.Getenv("WS_SEND_BUFFER_SIZE"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("WS_SEND_BUFFER_SIZE: %w", err)
+		}
+		cfg.WebSocket.SendBufferSize = size
+	}
+	if err := setDuration(&cfg.WebSocket.WriteTimeout, "WS_WRITE_TIMEOUT"); err != nil {
+		return err
+	}
+	if err := setDuration(&cfg.WebSocket.PingInterval, "WS_PING_INTERVAL"); err != nil {
+		return err
+	}
+	if err := setDuration(&cfg.WebSocket.PongTimeout, "WS_PONG_TIMEOUT"); err != nil {
+		return err
+	}
+	if v := os.Getenv("WS_MAX_MESSAGE_SIZE"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("WS_MAX_MESSAGE_SIZE: %w", err)
+		}
+		cfg.WebSocket.MaxMessageSize = size
+	}
+
+	if err := setDuration(&cfg.Chat.EditWindow, "CHAT_EDIT_WINDOW"); err != nil {
+		return err
+	}
+	if err := setInt(&cfg.Chat.HistoryPageSize, "CHAT_HISTORY_PAGE_SIZE"); err != nil {
+		return err
+	}
+	if err := setInt(&cfg.Chat.MaxHistoryPageSize, "CHAT_MAX_HISTORY_PAGE_SIZE"); err != nil {
+		return err
+	}
+	if err := setInt(&cfg.Chat.MaxContentLength, "CHAT_MAX_CONTENT_LENGTH"); err != nil {
+		return err
+	}
+	if err := setInt(&cfg.Chat.MaxGroupDMSize, "CHAT_MAX_GROUP_DM_SIZE"); err != nil {
+		return err
+	}
+
+	setString(&cfg.Hub.Driver, "HUB_DRIVER")
+	setString(&cfg.Hub.Address, "HUB_ADDRESS")
+	setString(&cfg.Hub.Password, "HUB_PASSWORD")
+	setString(&cfg.Hub.Prefix, "HUB_PREFIX")
+
+	if err := setFloat(&cfg.RateLimit.MessagesPerSecond, "RATE_MESSAGES_PER_SECOND"); err != nil {
+		return err
+	}
+	if err := setInt(&cfg.RateLimit.MessageBurst, "RATE_MESSAGE_BURST"); err != nil {
+		return err
+	}
+	if err := setFloat(&cfg.RateLimit.TypingPerSecond, "RATE_TYPING_PER_SECOND"); err != nil {
+		return err
+	}
+	if err := setInt(&cfg.RateLimit.TypingBurst, "RATE_TYPING_BURST"); err != nil {
+		return err
+	}
+	if err := setInt(&cfg.RateLimit.MaxViolations, "RATE_MAX_VIOLATIONS"); err != nil {
+		return err
+	}
+	if err := setDuration(&cfg.RateLimit.ViolationWindow, "RATE_VIOLATION_WINDOW"); err != nil {
+		return err
+	}
+	if err := setDuration(&cfg.RateLimit.BanDuration, "RATE_BAN_DURATION"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func setString(dst *string, key string) {
+	if v := os.Getenv(key); v != "" {
+		*dst = v
+	}
+}
+
+func setInt(dst *int, key string) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = n
+	return nil
+}
+
+func setFloat(dst *float64, key string) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = f
+	return nil
+}
+
+func setDuration(dst *Duration, key string) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	dst.Duration = d
+	return nil
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -7,9 +7,9 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func InitDB() {
+func InitDB(path string) {
 	var err error
-	Instance, err = sql.Open("sqlite3", "./forum.db")
+	Instance, err = sql.Open("sqlite3", path)
 	if err != nil {
 		log.Fatal(err)
 	}
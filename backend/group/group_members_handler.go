@@ -1,6 +1,7 @@
 package group
 
 import (
+	"backend/chat"
 	"backend/db"
 	"database/sql"
 	"encoding/json"
@@ -84,6 +85,8 @@ func RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("[Groups] User %d removed user %d from group %d", userID, req.MemberToRemove, req.GroupID)
+	chat.GroupMembershipChanged(chat.GroupMemberChanged{GroupID: req.GroupID, UserID: req.MemberToRemove,
+		Change: chat.MemberRemoved, ByUserID: userID})
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Member removed successfully"})
 }
@@ -157,6 +160,8 @@ func PromoteMemberHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("[Groups] User %d changed role of user %d to %s in group %d", userID, req.MemberToPromote, req.NewRole, req.GroupID)
+	chat.GroupMembershipChanged(chat.GroupMemberChanged{GroupID: req.GroupID, UserID: req.MemberToPromote,
+		Change: chat.MemberRoleChanged, Role: req.NewRole, ByUserID: userID})
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Member role updated successfully"})
 }
@@ -1,6 +1,7 @@
 package group
 
 import (
+	"backend/chat"
 	"backend/db"
 	"backend/event"
 	"backend/notification"
@@ -83,6 +84,8 @@ func CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("[Groups] User %d created new group (ID: %d)", userID, groupID)
+	chat.GroupMembershipChanged(chat.GroupMemberChanged{GroupID: group.GroupID, UserID: userID,
+		Change: chat.MemberJoined, Role: "creator"})
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(group)
 }
@@ -361,6 +364,7 @@ func LeaveGroupHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("[Groups] User %d left group %d", userID, req.GroupID)
+	chat.GroupMembershipChanged(chat.GroupMemberChanged{GroupID: req.GroupID, UserID: userID, Change: chat.MemberLeft})
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Successfully left group"})
 }
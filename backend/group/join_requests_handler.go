@@ -1,6 +1,7 @@
 package group
 
 import (
+	"backend/chat"
 	"backend/db"
 	"backend/notification"
 	"encoding/json"
@@ -202,6 +203,13 @@ func RespondToGroupRequestHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Subscribe the new member's open connections to the group
+		joined := chat.GroupMemberChanged{GroupID: req.GroupID, UserID: targetUserID, Change: chat.MemberJoined, Role: "member"}
+		if targetUserID != userID {
+			joined.ByUserID = userID
+		}
+		chat.GroupMembershipChanged(joined)
+
 		// Create notification AFTER successful commit
 		var message string
 		if req.RequestType == "invitation" {
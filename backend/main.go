@@ -3,6 +3,7 @@ package main
 import (
 	"backend/chat"
 	"backend/comment"
+	"backend/config"
 	"backend/db"
 	"backend/event"
 	"backend/follower"
@@ -11,26 +12,81 @@ import (
 	"backend/post"
 
 	"backend/pkg/db/sqlite"
+	"backend/pkg/hub"
 	"backend/user"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
+// Origins allowed by withCORS, set from the server config
+var allowedOrigins config.ServerConfig
+
 func main() {
+	configPath := flag.String("config", "", "path to a JSON config file (defaults to $CONFIG_FILE)")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Hand each package its settings
+	allowedOrigins = cfg.Server
+	chat.Configure(cfg.Server, cfg.WebSocket, cfg.Chat, cfg.Uploads, cfg.RateLimit)
+	chat.LoadNotificationsSince = notification.NotificationsSince
+	chat.RegisterEvent("notification", notification.NotificationData{})
+	post.Configure(cfg.Uploads)
+	user.Configure(cfg.Auth, cfg.Uploads)
+
 	// Initialize the database
-	db.InitDB()
-	sqlite.ApplyMigrations()
+	db.InitDB(cfg.Database.Path)
+	sqlite.ApplyMigrations(cfg.Database)
+	chat.SearchAvailable = sqlite.ApplySearchMigrations(cfg.Database)
 	defer db.Instance.Close()
+	go chat.SweepAttachments()
+
+	// Realtime frames and presence go through the hub, so several instances can serve the same users
+	realtime, err := hub.New(cfg.Hub, chat.Local)
+	if err != nil {
+		log.Fatal("Failed to start realtime hub: ", err)
+	}
+	chat.UseHub(realtime)
+	defer realtime.Close()
+
+	// Load JWT signing keys; SIGHUP re-reads the config and reloads them after a rotation
+	if err := user.InitKeys(cfg.Auth); err != nil {
+		log.Fatal("Failed to load signing keys: ", err)
+	}
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			reloaded, err := config.Load(*configPath)
+			if err == nil {
+				err = user.ReloadKeys(reloaded.Auth)
+			}
+			if err != nil {
+				log.Printf("[JWT] Key reload failed, keeping current keys: %v", err)
+			}
+		}
+	}()
 
 	// Serve static files (images/videos)
-	fs := http.FileServer(http.Dir("uploads"))
+	fs := http.FileServer(http.Dir(cfg.Uploads.Dir))
 	http.Handle("/uploads/", http.StripPrefix("/uploads/", fs))
 
 	// Auth
 	http.HandleFunc("/register", withCORS(user.RegisterHandler))
 	http.HandleFunc("/login", withCORS(user.LoginHandler))
 	http.HandleFunc("/upload-avatar", withCORS(user.UploadAvatarHandler))
+	http.HandleFunc("/auth/refresh", withCORS(user.RefreshHandler))
+	http.HandleFunc("/auth/logout", withCORS(user.LogoutHandler))
+	http.HandleFunc("/auth/logout-all", withCORS(user.JwtMiddleware(user.LogoutAllHandler)))
 
 	// Posts & comments
 	http.HandleFunc("/posts", withCORS(user.JwtMiddleware(post.CreatePostHandler)))
@@ -42,9 +98,29 @@ func main() {
 
 	// Chat & WebSocket
 	http.HandleFunc("/ws", withCORS(chat.HandleConnections))
+	http.HandleFunc("/ws/schema", withCORS(chat.ProtocolSchemaHandler))
 	http.HandleFunc("/private-messages", withCORS(user.JwtMiddleware(chat.GetPrivateMessagesHandler)))
 	http.HandleFunc("/group-messages", withCORS(user.JwtMiddleware(chat.GetGroupMessagesHandler)))
 	http.HandleFunc("/chat-list", withCORS(user.JwtMiddleware(chat.GetMessageableUsersAndGroupsHandler)))
+	http.HandleFunc("/chat/read", withCORS(user.JwtMiddleware(chat.MarkConversationReadHandler)))
+	http.HandleFunc("/chat/settings", withCORS(user.JwtMiddleware(chat.ConversationSettingsHandler)))
+	http.HandleFunc("/chat/requests", withCORS(user.JwtMiddleware(chat.MessageRequestsHandler)))
+	http.HandleFunc("/chat/requests/respond", withCORS(user.JwtMiddleware(chat.RespondToMessageRequestHandler)))
+	http.HandleFunc("/chat/dms", withCORS(user.JwtMiddleware(chat.GroupDMHandler)))
+	http.HandleFunc("/chat/dms/create", withCORS(user.JwtMiddleware(chat.CreateGroupDMHandler)))
+	http.HandleFunc("/chat/dms/messages", withCORS(user.JwtMiddleware(chat.GroupDMMessagesHandler)))
+	http.HandleFunc("/chat/dms/add", withCORS(user.JwtMiddleware(chat.AddToGroupDMHandler)))
+	http.HandleFunc("/chat/dms/remove", withCORS(user.JwtMiddleware(chat.RemoveFromGroupDMHandler)))
+	http.HandleFunc("/chat/dms/leave", withCORS(user.JwtMiddleware(chat.LeaveGroupDMHandler)))
+	http.HandleFunc("/chat/dms/rename", withCORS(user.JwtMiddleware(chat.RenameGroupDMHandler)))
+	http.HandleFunc("/chat/message-edits", withCORS(user.JwtMiddleware(chat.GetMessageEditsHandler)))
+	http.HandleFunc("/chat/attachments", withCORS(user.JwtMiddleware(chat.UploadAttachmentHandler)))
+	http.HandleFunc("/chat/search", withCORS(user.JwtMiddleware(chat.SearchMessagesHandler)))
+	http.HandleFunc("/chat/thread", withCORS(user.JwtMiddleware(chat.GetThreadHandler)))
+	http.HandleFunc("/presence", withCORS(user.JwtMiddleware(chat.PresenceHandler)))
+	http.HandleFunc("/sync", withCORS(user.JwtMiddleware(chat.SyncHandler)))
+	http.HandleFunc("/chat/stream", withCORS(user.JwtMiddleware(chat.EventStreamHandler)))
+	http.HandleFunc("/chat/stream/send", withCORS(user.JwtMiddleware(chat.StreamSendHandler)))
 
 	// Social
 	http.HandleFunc("/follow", withCORS(user.JwtMiddleware(follower.FollowUserHandler)))
@@ -94,17 +170,23 @@ func main() {
 	http.HandleFunc("/user/profile/details", withCORS(user.JwtMiddleware(user.GetFullUserProfileHandler)))
 	http.HandleFunc("/user/profile/update", withCORS(user.JwtMiddleware(user.UpdateUserProfileHandler)))
 
-	fmt.Println("Server running on port 8088")
-	log.Fatal(http.ListenAndServe(":8088", nil))
+	fmt.Printf("Server running on port %d\n", cfg.Server.Port)
+	log.Fatal(http.ListenAndServe(cfg.Server.Addr(), nil))
 }
 
 // Global CORS wrapper
 func withCORS(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// You can restrict origin if you want, e.g. "http://localhost:3000"
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		// Restrict origins with CORS_ALLOWED_ORIGINS, e.g. "http://localhost:3000"
+		origin := r.Header.Get("Origin")
+		if len(allowedOrigins.AllowedOrigins) == 1 && allowedOrigins.AllowedOrigins[0] == "*" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else if origin != "" && allowedOrigins.AllowsOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
 
 		// Handle preflight
 		if r.Method == http.MethodOptions {
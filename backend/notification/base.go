@@ -66,21 +66,19 @@ func CreateNotification(userID int, notificationType, message string, relatedUse
 
 // Send notification to user via WebSocket
 func SendNotificationViaWebSocket(userID int, notification Notification) {
-	chat.ClientsMux.Lock()
-	client, exists := chat.Clients[userID]
-	chat.ClientsMux.Unlock()
-
-	if exists {
-		notificationData := NotificationData{
-			Type:         "notification",
-			Notification: notification,
-		}
+	notificationData := NotificationData{
+		Type:         "notification",
+		Notification: notification,
+	}
 
-		if err := client.Conn.WriteJSON(notificationData); err != nil {
-			log.Printf("Error sending notification to user %d: %v", userID, err)
-		} else {
-			log.Printf("Notification sent to user %d via WebSocket", userID)
-		}
+	// Activity in a group the user muted is stored but doesn't interrupt them
+	if notification.RelatedGroupID != nil && chat.ConversationMuted(userID, "group", *notification.RelatedGroupID) {
+		log.Printf("Group %d is muted for user %d, notification stored in database", *notification.RelatedGroupID, userID)
+		return
+	}
+
+	if chat.SendToUser(userID, notificationData) {
+		log.Printf("Notification queued for user %d via WebSocket", userID)
 	} else {
 		log.Printf("User %d not connected, notification stored in database", userID)
 	}
@@ -19,3 +19,7 @@ type NotificationData struct {
 	Type         string       `json:"type"`
 	Notification Notification `json:"notification"`
 }
+
+func (n NotificationData) EventType() string {
+	return n.Type
+}
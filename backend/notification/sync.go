@@ -0,0 +1,44 @@
+package notification
+
+import (
+	"backend/chat"
+	"backend/db"
+	"time"
+)
+
+// NotificationsSince loads a user's notifications after afterID as sync events,
+// framed like SendNotificationViaWebSocket frames them
+func NotificationsSince(userID, afterID, limit int) ([]chat.SyncEvent, error) {
+	rows, err := db.Instance.Query(`
+		SELECT notification_id, user_id, type, message, read_status, created_at
+		FROM notifications
+		WHERE user_id = ? AND notification_id > ?
+		ORDER BY notification_id ASC
+		LIMIT ?`, userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []chat.SyncEvent
+	for rows.Next() {
+		var notification Notification
+		var createdAt time.Time
+		if err := rows.Scan(&notification.ID, &notification.UserID, &notification.Type,
+			&notification.Message, &notification.ReadStatus, &createdAt); err != nil {
+			return nil, err
+		}
+		notification.CreatedAt = createdAt.Format(time.RFC3339)
+
+		events = append(events, chat.SyncEvent{
+			Kind: "notification",
+			ID:   notification.ID,
+			At:   createdAt,
+			Frame: NotificationData{
+				Type:         "notification",
+				Notification: notification,
+			},
+		})
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,9 @@
+-- =====================
+-- DOWN MIGRATION
+-- =====================
+
+DROP INDEX IF EXISTS idx_group_message_receipts_user;
+DROP TABLE IF EXISTS group_message_receipts;
+
+ALTER TABLE messages DROP COLUMN read_at;
+ALTER TABLE messages DROP COLUMN delivered_at;
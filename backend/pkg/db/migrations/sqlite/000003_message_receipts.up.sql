@@ -0,0 +1,20 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- Private messages have a single recipient, so their state lives on the row itself
+ALTER TABLE messages ADD COLUMN delivered_at TIMESTAMP NULL;
+ALTER TABLE messages ADD COLUMN read_at TIMESTAMP NULL;
+
+-- 15. Group Message Receipts (one row per message per member who received it)
+CREATE TABLE group_message_receipts (
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    delivered_at TIMESTAMP NULL,
+    read_at TIMESTAMP NULL,
+    PRIMARY KEY (message_id, user_id),
+    FOREIGN KEY (message_id) REFERENCES group_messages(message_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_group_message_receipts_user ON group_message_receipts(user_id, message_id);
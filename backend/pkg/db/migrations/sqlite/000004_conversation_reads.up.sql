@@ -0,0 +1,27 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- 16. Conversation Read Markers (newest message each user has read per conversation)
+CREATE TABLE conversation_reads (
+    user_id INTEGER NOT NULL,
+    conversation_type TEXT CHECK(conversation_type IN ('user','group')) NOT NULL,
+    conversation_id INTEGER NOT NULL, -- the other user's id or the group id
+    last_read_message_id INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, conversation_type, conversation_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Existing history counts as read, so badges only show messages sent after this migration
+INSERT OR IGNORE INTO conversation_reads (user_id, conversation_type, conversation_id, last_read_message_id)
+SELECT receiver_id, 'user', sender_id, MAX(message_id)
+FROM messages
+GROUP BY receiver_id, sender_id;
+
+INSERT OR IGNORE INTO conversation_reads (user_id, conversation_type, conversation_id, last_read_message_id)
+SELECT gms.user_id, 'group', gms.group_id, MAX(gm.message_id)
+FROM group_memberships gms
+JOIN group_messages gm ON gm.group_id = gms.group_id
+WHERE gms.status = 'accepted'
+GROUP BY gms.user_id, gms.group_id;
@@ -0,0 +1,22 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- A deleted message keeps its row as a tombstone so ids, receipts and read markers stay valid
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP NULL;
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMP NULL;
+ALTER TABLE group_messages ADD COLUMN edited_at TIMESTAMP NULL;
+ALTER TABLE group_messages ADD COLUMN deleted_at TIMESTAMP NULL;
+
+-- 17. Message Edit History (the content each edit replaced; cleared when the message is deleted)
+CREATE TABLE message_edits (
+    edit_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    conversation_type TEXT CHECK(conversation_type IN ('user','group')) NOT NULL,
+    message_id INTEGER NOT NULL,
+    editor_id INTEGER NOT NULL,
+    previous_content TEXT,
+    edited_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (editor_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_message_edits_message ON message_edits(conversation_type, message_id);
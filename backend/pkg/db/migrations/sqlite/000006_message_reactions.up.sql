@@ -0,0 +1,14 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- 18. Message Reactions (one row per user per emoji per message)
+CREATE TABLE message_reactions (
+    conversation_type TEXT CHECK(conversation_type IN ('user','group')) NOT NULL,
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    emoji TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (conversation_type, message_id, user_id, emoji),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
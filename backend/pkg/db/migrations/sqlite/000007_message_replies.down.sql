@@ -0,0 +1,9 @@
+-- =====================
+-- DOWN MIGRATION
+-- =====================
+
+DROP INDEX IF EXISTS idx_group_messages_reply_to;
+DROP INDEX IF EXISTS idx_messages_reply_to;
+
+ALTER TABLE group_messages DROP COLUMN reply_to_id;
+ALTER TABLE messages DROP COLUMN reply_to_id;
@@ -0,0 +1,10 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- A reply points at a message in the same conversation; threads are followed through these links
+ALTER TABLE messages ADD COLUMN reply_to_id INTEGER NULL REFERENCES messages(message_id);
+ALTER TABLE group_messages ADD COLUMN reply_to_id INTEGER NULL REFERENCES group_messages(message_id);
+
+CREATE INDEX idx_messages_reply_to ON messages(reply_to_id);
+CREATE INDEX idx_group_messages_reply_to ON group_messages(reply_to_id);
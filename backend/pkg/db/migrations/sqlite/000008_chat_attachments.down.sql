@@ -0,0 +1,6 @@
+-- =====================
+-- DOWN MIGRATION
+-- =====================
+
+DROP INDEX IF EXISTS idx_chat_attachments_message;
+DROP TABLE IF EXISTS chat_attachments;
@@ -0,0 +1,19 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- 19. Chat Attachments (uploaded first, then claimed by exactly one message)
+CREATE TABLE chat_attachments (
+    attachment_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uploader_id INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    mime_type TEXT NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    original_name TEXT,
+    conversation_type TEXT CHECK(conversation_type IN ('user','group')) NULL,
+    message_id INTEGER NULL, -- NULL until a message references it
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (uploader_id) REFERENCES users(id)
+);
+
+CREATE UNIQUE INDEX idx_chat_attachments_message ON chat_attachments(conversation_type, message_id);
@@ -0,0 +1,7 @@
+-- =====================
+-- DOWN MIGRATION
+-- =====================
+
+DROP INDEX IF EXISTS idx_group_messages_group;
+DROP INDEX IF EXISTS idx_messages_receiver;
+DROP INDEX IF EXISTS idx_messages_conversation;
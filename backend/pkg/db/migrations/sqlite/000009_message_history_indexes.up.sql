@@ -0,0 +1,8 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- Keyset pagination walks a conversation by message_id, so index each conversation in id order
+CREATE INDEX idx_messages_conversation ON messages(sender_id, receiver_id, message_id);
+CREATE INDEX idx_messages_receiver ON messages(receiver_id, message_id);
+CREATE INDEX idx_group_messages_group ON group_messages(group_id, message_id);
@@ -0,0 +1,13 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- 21. User Presence (chosen status and when the user was last connected; online itself is in memory)
+CREATE TABLE user_presence (
+    user_id INTEGER PRIMARY KEY,
+    status TEXT CHECK(status IN ('available','away','dnd')) NOT NULL DEFAULT 'available',
+    status_text TEXT,
+    last_seen_at TIMESTAMP NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
@@ -0,0 +1,16 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- 22. Conversation Settings (per user; no row means not muted, archived or pinned)
+CREATE TABLE conversation_settings (
+    user_id INTEGER NOT NULL,
+    conversation_type TEXT CHECK(conversation_type IN ('user','group')) NOT NULL,
+    conversation_id INTEGER NOT NULL, -- the other user's id or the group id
+    muted_until TIMESTAMP NULL, -- RFC 3339 in UTC; far in the future for "until unmuted"
+    archived BOOLEAN NOT NULL DEFAULT 0,
+    pinned_at TIMESTAMP NULL, -- pinned conversations sort first, most recently pinned first
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, conversation_type, conversation_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
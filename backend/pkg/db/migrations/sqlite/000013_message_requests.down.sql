@@ -0,0 +1,5 @@
+-- =====================
+-- DOWN MIGRATION
+-- =====================
+
+DROP TABLE IF EXISTS message_requests;
@@ -0,0 +1,21 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- 23. Message Requests (a first message to someone the sender may not message freely; nothing more
+-- goes through until the receiver accepts)
+CREATE TABLE message_requests (
+    sender_id INTEGER NOT NULL,
+    receiver_id INTEGER NOT NULL,
+    message_id INTEGER NOT NULL, -- the message that opened the request
+    status TEXT CHECK(status IN ('pending','accepted','declined','blocked')) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    responded_at TIMESTAMP NULL,
+    PRIMARY KEY (sender_id, receiver_id),
+    FOREIGN KEY (sender_id) REFERENCES users(id),
+    FOREIGN KEY (receiver_id) REFERENCES users(id),
+    FOREIGN KEY (message_id) REFERENCES messages(message_id)
+);
+
+CREATE INDEX idx_message_requests_receiver ON message_requests(receiver_id, status);
+CREATE UNIQUE INDEX idx_message_requests_message ON message_requests(message_id);
@@ -0,0 +1,23 @@
+-- =====================
+-- DOWN MIGRATION
+-- =====================
+
+CREATE TABLE conversation_settings_old (
+    user_id INTEGER NOT NULL,
+    conversation_type TEXT CHECK(conversation_type IN ('user','group')) NOT NULL,
+    conversation_id INTEGER NOT NULL,
+    muted_until TIMESTAMP NULL,
+    archived BOOLEAN NOT NULL DEFAULT 0,
+    pinned_at TIMESTAMP NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, conversation_type, conversation_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+INSERT INTO conversation_settings_old SELECT * FROM conversation_settings WHERE conversation_type != 'group_dm';
+DROP TABLE conversation_settings;
+ALTER TABLE conversation_settings_old RENAME TO conversation_settings;
+
+DROP TABLE IF EXISTS group_dm_messages;
+DROP TABLE IF EXISTS group_dm_participants;
+DROP TABLE IF EXISTS group_dms;
@@ -0,0 +1,58 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- 24. Group DMs (ad-hoc conversations among three or more users, outside any group)
+CREATE TABLE group_dms (
+    dm_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    title TEXT NOT NULL DEFAULT '', -- empty: clients name it after the participants
+    creator_id INTEGER NOT NULL, -- may remove participants; passes on when they leave
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (creator_id) REFERENCES users(id)
+);
+
+-- 25. Group DM Participants
+CREATE TABLE group_dm_participants (
+    dm_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    added_by INTEGER NULL,
+    joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    visible_after INTEGER NOT NULL DEFAULT 0, -- newest message_id when they were added; earlier history stays hidden
+    last_read_message_id INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (dm_id, user_id),
+    FOREIGN KEY (dm_id) REFERENCES group_dms(dm_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (added_by) REFERENCES users(id)
+);
+
+CREATE INDEX idx_group_dm_participants_user ON group_dm_participants(user_id);
+
+-- 26. Group DM Messages
+CREATE TABLE group_dm_messages (
+    message_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    dm_id INTEGER NOT NULL,
+    sender_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (dm_id) REFERENCES group_dms(dm_id) ON DELETE CASCADE,
+    FOREIGN KEY (sender_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_group_dm_messages_dm ON group_dm_messages(dm_id, message_id);
+
+-- 22. Conversation Settings, rebuilt so group DMs can be muted, archived and pinned too
+CREATE TABLE conversation_settings_new (
+    user_id INTEGER NOT NULL,
+    conversation_type TEXT CHECK(conversation_type IN ('user','group','group_dm')) NOT NULL,
+    conversation_id INTEGER NOT NULL, -- the other user's id, the group id or the dm_id
+    muted_until TIMESTAMP NULL, -- RFC 3339 in UTC; far in the future for "until unmuted"
+    archived BOOLEAN NOT NULL DEFAULT 0,
+    pinned_at TIMESTAMP NULL, -- pinned conversations sort first, most recently pinned first
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, conversation_type, conversation_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+INSERT INTO conversation_settings_new SELECT * FROM conversation_settings;
+DROP TABLE conversation_settings;
+ALTER TABLE conversation_settings_new RENAME TO conversation_settings;
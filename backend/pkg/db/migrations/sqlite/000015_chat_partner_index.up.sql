@@ -0,0 +1,7 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- Presence lists whoever a user has chatted with; with idx_messages_conversation this lets
+-- both directions be read from an index alone
+CREATE INDEX idx_messages_partner ON messages(receiver_id, sender_id);
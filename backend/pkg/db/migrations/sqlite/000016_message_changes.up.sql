@@ -0,0 +1,15 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- 27. Message Changes (edits, deletes and reactions in order, so sync can replay them after a cursor)
+CREATE TABLE message_changes (
+    change_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    conversation_type TEXT CHECK(conversation_type IN ('user','group')) NOT NULL,
+    message_id INTEGER NOT NULL,
+    change TEXT CHECK(change IN ('message_edited','message_deleted','reaction_added','reaction_removed')) NOT NULL,
+    actor_id INTEGER NOT NULL,
+    emoji TEXT, -- reactions only
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (actor_id) REFERENCES users(id)
+);
@@ -0,0 +1,6 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- Uploads not yet claimed by a message, for the per-user quota and the expiry sweep
+CREATE INDEX idx_chat_attachments_pending ON chat_attachments(uploader_id, created_at) WHERE message_id IS NULL;
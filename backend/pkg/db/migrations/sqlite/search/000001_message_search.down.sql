@@ -0,0 +1,13 @@
+-- =====================
+-- DOWN MIGRATION
+-- =====================
+
+DROP TRIGGER IF EXISTS group_messages_fts_update;
+DROP TRIGGER IF EXISTS group_messages_fts_delete;
+DROP TRIGGER IF EXISTS group_messages_fts_insert;
+DROP TRIGGER IF EXISTS messages_fts_update;
+DROP TRIGGER IF EXISTS messages_fts_delete;
+DROP TRIGGER IF EXISTS messages_fts_insert;
+
+DROP TABLE IF EXISTS group_messages_fts;
+DROP TABLE IF EXISTS messages_fts;
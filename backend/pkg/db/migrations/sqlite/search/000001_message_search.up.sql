@@ -0,0 +1,60 @@
+-- =====================
+-- UP MIGRATION
+-- =====================
+
+-- 20. Message Search (FTS5 indexes over message content; needs a build with -tags sqlite_fts5)
+-- Applied apart from the main migrations, and only by builds with FTS5. A build without it drops
+-- the triggers and forgets this migration, so the next FTS5 build starts the index afresh.
+-- External-content tables: the text lives only in messages/group_messages, the triggers keep the index in step
+DROP TRIGGER IF EXISTS group_messages_fts_update;
+DROP TRIGGER IF EXISTS group_messages_fts_delete;
+DROP TRIGGER IF EXISTS group_messages_fts_insert;
+DROP TRIGGER IF EXISTS messages_fts_update;
+DROP TRIGGER IF EXISTS messages_fts_delete;
+DROP TRIGGER IF EXISTS messages_fts_insert;
+DROP TABLE IF EXISTS group_messages_fts;
+DROP TABLE IF EXISTS messages_fts;
+
+CREATE VIRTUAL TABLE messages_fts USING fts5(
+    content,
+    content='messages',
+    content_rowid='message_id',
+    tokenize='unicode61 remove_diacritics 2'
+);
+
+CREATE VIRTUAL TABLE group_messages_fts USING fts5(
+    content,
+    content='group_messages',
+    content_rowid='message_id',
+    tokenize='unicode61 remove_diacritics 2'
+);
+
+INSERT INTO messages_fts(messages_fts) VALUES ('rebuild');
+INSERT INTO group_messages_fts(group_messages_fts) VALUES ('rebuild');
+
+CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+    INSERT INTO messages_fts(rowid, content) VALUES (new.message_id, new.content);
+END;
+
+CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+    INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.message_id, old.content);
+END;
+
+-- Covers edits and tombstoning, which blanks the content
+CREATE TRIGGER messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+    INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.message_id, old.content);
+    INSERT INTO messages_fts(rowid, content) VALUES (new.message_id, new.content);
+END;
+
+CREATE TRIGGER group_messages_fts_insert AFTER INSERT ON group_messages BEGIN
+    INSERT INTO group_messages_fts(rowid, content) VALUES (new.message_id, new.content);
+END;
+
+CREATE TRIGGER group_messages_fts_delete AFTER DELETE ON group_messages BEGIN
+    INSERT INTO group_messages_fts(group_messages_fts, rowid, content) VALUES ('delete', old.message_id, old.content);
+END;
+
+CREATE TRIGGER group_messages_fts_update AFTER UPDATE OF content ON group_messages BEGIN
+    INSERT INTO group_messages_fts(group_messages_fts, rowid, content) VALUES ('delete', old.message_id, old.content);
+    INSERT INTO group_messages_fts(rowid, content) VALUES (new.message_id, new.content);
+END;
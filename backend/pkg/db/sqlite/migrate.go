@@ -1,9 +1,11 @@
 package sqlite
 
 import (
+	"backend/config"
 	"database/sql"
 	"fmt"
 	"log"
+	"path/filepath"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
@@ -14,25 +16,29 @@ import (
 var db *sql.DB
 
 // Connect initializes the database connection and applies migrations
-func Connect() *sql.DB {
+func Connect(cfg config.DatabaseConfig) *sql.DB {
 	var err error
-	db, err = sql.Open("sqlite3", "forum.db")
+	db, err = sql.Open("sqlite3", cfg.Path)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	// Apply migrations
-	ApplyMigrations()
+	ApplyMigrations(cfg)
 
 	return db
 }
 
-// ApplyMigrations runs database migrations
-func ApplyMigrations() {
-	m, err := migrate.New(
-		"file://pkg/db/migrations/sqlite",
-		"sqlite3://forum.db",
+func newMigrate(cfg config.DatabaseConfig) (*migrate.Migrate, error) {
+	return migrate.New(
+		"file://"+cfg.MigrationsPath,
+		"sqlite3://"+cfg.Path,
 	)
+}
+
+// ApplyMigrations runs database migrations
+func ApplyMigrations(cfg config.DatabaseConfig) {
+	m, err := newMigrate(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -44,11 +50,73 @@ func ApplyMigrations() {
 	fmt.Println("Migrations applied successfully!")
 }
 
-func RollbackLastMigration() {
+// searchMigrationsTable records the search migrations apart from the main ones
+const searchMigrationsTable = "search_schema_migrations"
+
+// Search triggers, dropped when the build can't maintain the index they write to
+var searchTriggers = []string{
+	"messages_fts_insert", "messages_fts_delete", "messages_fts_update",
+	"group_messages_fts_insert", "group_messages_fts_delete", "group_messages_fts_update",
+}
+
+// hasFTS5 reports whether this binary's SQLite has FTS5, which go-sqlite3 only compiles in
+// under the sqlite_fts5 build tag
+func hasFTS5() bool {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	_, err = conn.Exec(`CREATE VIRTUAL TABLE fts5_probe USING fts5(x)`)
+	return err == nil
+}
+
+// ApplySearchMigrations sets up the message search index, kept in migrations/search with a
+// version table of its own. It reports whether search is available: without FTS5 it turns the
+// index off instead, so messages can still be written, and the server runs without search.
+func ApplySearchMigrations(cfg config.DatabaseConfig) bool {
+	if !hasFTS5() {
+		log.Printf("[Search] SQLite was built without FTS5, so message search is off; build with -tags sqlite_fts5 (make build) to turn it on")
+		if err := dropSearchIndex(cfg); err != nil {
+			log.Fatal("Failed to turn off the search index: ", err)
+		}
+		return false
+	}
+
 	m, err := migrate.New(
-		"file://backend/pkg/db/migrations/sqlite",
-		"sqlite3://forum.db",
+		"file://"+filepath.Join(cfg.MigrationsPath, "search"),
+		"sqlite3://"+cfg.Path+"?x-migrations-table="+searchMigrationsTable,
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		log.Fatal(err)
+	}
+	return true
+}
+
+// dropSearchIndex removes the triggers that would fail every message insert without FTS5, and
+// forgets the search migration so a later FTS5 build rebuilds the index from scratch
+func dropSearchIndex(cfg config.DatabaseConfig) error {
+	conn, err := sql.Open("sqlite3", cfg.Path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, trigger := range searchTriggers {
+		if _, err := conn.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Exec("DROP TABLE IF EXISTS " + searchMigrationsTable)
+	return err
+}
+
+func RollbackLastMigration(cfg config.DatabaseConfig) {
+	m, err := newMigrate(cfg)
 	if err != nil {
 		log.Fatal("Failed to initialize migrations:", err)
 	}
@@ -0,0 +1,56 @@
+package hub
+
+import (
+	"backend/config"
+	"encoding/json"
+	"fmt"
+)
+
+// Frame is an encoded websocket frame on its way to a user's or a group's connections
+type Frame struct {
+	Type          string          `json:"type"` // envelope type and id, the same on every connection it reaches
+	ID            string          `json:"id"`
+	Data          json.RawMessage `json:"data"`
+	ExceptSession string          `json:"except_session,omitempty"` // the connection it came from, which already has it
+	ExceptUserID  int             `json:"except_user_id,omitempty"` // group frames only, usually the sender
+	Droppable     bool            `json:"droppable,omitempty"`      // typing and the like: dropped rather than disconnecting a slow client
+
+	// User frames only: group subscriptions of the user's connections to change before delivery,
+	// on whichever instances hold them
+	JoinGroup  int `json:"join_group,omitempty"`
+	LeaveGroup int `json:"leave_group,omitempty"`
+}
+
+// Local hands frames to the connections held by this instance
+type Local interface {
+	DeliverToUser(userID int, f Frame)
+	DeliverToGroup(groupID int, f Frame)
+}
+
+// Hub carries realtime frames and presence between backend instances.
+// Publishing delivers to this instance's connections straight away and to every other instance's
+// through the hub, so a user is reached whichever replica their connections landed on.
+type Hub interface {
+	PublishToUser(userID int, f Frame) error
+	PublishToGroup(groupID int, f Frame) error
+
+	// Connected and Disconnected count a user's sessions across all instances and
+	// report whether this was their first or last one
+	Connected(userID int) (bool, error)
+	Disconnected(userID int) (bool, error)
+	Online(userID int) (bool, error)
+
+	Close() error
+}
+
+// New builds the hub selected by the config
+func New(cfg config.HubConfig, local Local) (Hub, error) {
+	switch cfg.Driver {
+	case "", "memory":
+		return NewMemory(local), nil
+	case "redis":
+		return NewRedis(cfg, local)
+	default:
+		return nil, fmt.Errorf("unknown hub driver %q", cfg.Driver)
+	}
+}
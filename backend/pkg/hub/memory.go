@@ -0,0 +1,55 @@
+package hub
+
+import "sync"
+
+// Memory is the hub for a single instance: everything is local
+type Memory struct {
+	local Local
+
+	mu       sync.Mutex
+	sessions map[int]int
+}
+
+func NewMemory(local Local) *Memory {
+	return &Memory{local: local, sessions: make(map[int]int)}
+}
+
+func (m *Memory) PublishToUser(userID int, f Frame) error {
+	m.local.DeliverToUser(userID, f)
+	return nil
+}
+
+func (m *Memory) PublishToGroup(groupID int, f Frame) error {
+	m.local.DeliverToGroup(groupID, f)
+	return nil
+}
+
+func (m *Memory) Connected(userID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[userID]++
+	return m.sessions[userID] == 1, nil
+}
+
+func (m *Memory) Disconnected(userID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sessions[userID] <= 1 {
+		delete(m.sessions, userID)
+		return true, nil
+	}
+	m.sessions[userID]--
+	return false, nil
+}
+
+func (m *Memory) Online(userID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[userID] > 0, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
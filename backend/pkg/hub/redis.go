@@ -0,0 +1,306 @@
+package hub
+
+import (
+	"backend/config"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	heartbeatInterval = 10 * time.Second
+	instanceTTL       = 30 * time.Second // an instance that misses this many seconds of heartbeats is treated as gone
+	maxResubscribe    = 30 * time.Second
+)
+
+// Redis shares frames and presence between instances through a Redis server.
+// Frames go out on one pub/sub channel. Each user's session counts live in a hash with one
+// field per instance, and a field only counts while its instance keeps its heartbeat key alive,
+// so a crashed replica's users drop offline after instanceTTL.
+type Redis struct {
+	local    Local
+	addr     string
+	password string
+	prefix   string
+	instance string
+
+	mu  sync.Mutex // guards cmd
+	cmd *respConn
+
+	subMu sync.Mutex // guards sub, so Close can interrupt the subscriber
+	sub   *respConn
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// envelope is what travels on the channel between instances
+type envelope struct {
+	Origin  string `json:"origin"`
+	UserID  int    `json:"user_id,omitempty"`
+	GroupID int    `json:"group_id,omitempty"`
+	Frame   Frame  `json:"frame"`
+}
+
+// NewRedis connects to the server and starts the subscriber and the heartbeat
+func NewRedis(cfg config.HubConfig, local Local) (*Redis, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	h := &Redis{
+		local:    local,
+		addr:     cfg.Address,
+		password: cfg.Password,
+		prefix:   cfg.Prefix,
+		instance: hex.EncodeToString(id),
+		done:     make(chan struct{}),
+	}
+	if err := h.heartbeat(); err != nil {
+		return nil, fmt.Errorf("redis hub at %s: %w", cfg.Address, err)
+	}
+
+	h.wg.Add(2)
+	go h.subscribe()
+	go h.keepAlive()
+	log.Printf("[Hub] Using redis at %s as instance %s", h.addr, h.instance)
+	return h, nil
+}
+
+func (h *Redis) channel() string {
+	return h.prefix + "frames"
+}
+
+func (h *Redis) instanceKey(instance string) string {
+	return h.prefix + "instance:" + instance
+}
+
+func (h *Redis) presenceKey(userID int) string {
+	return h.prefix + "presence:" + strconv.Itoa(userID)
+}
+
+// do runs a command on the shared connection, redialling if the last one broke
+func (h *Redis) do(args ...string) (interface{}, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.cmd == nil {
+		c, err := dialRESP(h.addr, h.password)
+		if err != nil {
+			return nil, err
+		}
+		h.cmd = c
+	}
+
+	reply, err := h.cmd.do(args...)
+	var replyErr respError
+	if err != nil && !errors.As(err, &replyErr) {
+		h.cmd.close()
+		h.cmd = nil
+	}
+	return reply, err
+}
+
+func (h *Redis) PublishToUser(userID int, f Frame) error {
+	h.local.DeliverToUser(userID, f)
+	return h.publish(envelope{UserID: userID, Frame: f})
+}
+
+func (h *Redis) PublishToGroup(groupID int, f Frame) error {
+	h.local.DeliverToGroup(groupID, f)
+	return h.publish(envelope{GroupID: groupID, Frame: f})
+}
+
+// publish hands a frame to the other instances; local connections already have it
+func (h *Redis) publish(e envelope) error {
+	e.Origin = h.instance
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = h.do("PUBLISH", h.channel(), string(data))
+	return err
+}
+
+// subscribe delivers frames published by other instances, reconnecting with backoff.
+// Frames published while it is reconnecting are missed; clients catch up through sync.
+func (h *Redis) subscribe() {
+	defer h.wg.Done()
+
+	backoff := time.Second
+	for {
+		err := h.listen(&backoff)
+		select {
+		case <-h.done:
+			return
+		default:
+		}
+
+		log.Printf("[Hub] Redis subscription lost, retrying in %v: %v", backoff, err)
+		select {
+		case <-h.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxResubscribe)
+	}
+}
+
+func (h *Redis) listen(backoff *time.Duration) error {
+	c, err := dialRESP(h.addr, h.password)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	h.subMu.Lock()
+	h.sub = c
+	h.subMu.Unlock()
+	select {
+	case <-h.done:
+		return nil
+	default:
+	}
+
+	if err := c.send("SUBSCRIBE", h.channel()); err != nil {
+		return err
+	}
+	*backoff = time.Second
+
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return err
+		}
+
+		// Skips the subscribe confirmation; only "message" pushes carry frames
+		push, ok := reply.([]interface{})
+		if !ok || len(push) != 3 || push[0] != "message" {
+			continue
+		}
+		payload, _ := push[2].(string)
+
+		var e envelope
+		if err := json.Unmarshal([]byte(payload), &e); err != nil {
+			log.Printf("[Hub] Dropping malformed frame: %v", err)
+			continue
+		}
+		if e.Origin == h.instance {
+			continue
+		}
+
+		if e.GroupID > 0 {
+			h.local.DeliverToGroup(e.GroupID, e.Frame)
+		} else {
+			h.local.DeliverToUser(e.UserID, e.Frame)
+		}
+	}
+}
+
+func (h *Redis) keepAlive() {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			if err := h.heartbeat(); err != nil {
+				log.Printf("[Hub] Heartbeat failed: %v", err)
+			}
+		}
+	}
+}
+
+func (h *Redis) heartbeat() error {
+	_, err := h.do("SET", h.instanceKey(h.instance), "1", "EX", strconv.Itoa(int(instanceTTL/time.Second)))
+	return err
+}
+
+// Connected counts a new session. Two instances racing on a user's first sessions may both
+// see two and neither report first; the next presence change corrects it.
+func (h *Redis) Connected(userID int) (bool, error) {
+	if _, err := h.do("HINCRBY", h.presenceKey(userID), h.instance, "1"); err != nil {
+		return false, err
+	}
+	total, err := h.sessions(userID)
+	return total == 1, err
+}
+
+// disconnectScript drops this instance's field once its count reaches zero. It runs as one
+// script so a Connected on another goroutine can't land between the decrement and the delete.
+const disconnectScript = `
+local n = redis.call('HINCRBY', KEYS[1], ARGV[1], -1)
+if n <= 0 then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return n`
+
+func (h *Redis) Disconnected(userID int) (bool, error) {
+	if _, err := h.do("EVAL", disconnectScript, "1", h.presenceKey(userID), h.instance); err != nil {
+		return false, err
+	}
+	total, err := h.sessions(userID)
+	return total == 0, err
+}
+
+func (h *Redis) Online(userID int) (bool, error) {
+	total, err := h.sessions(userID)
+	return total > 0, err
+}
+
+// sessionsScript totals a user's sessions on live instances, forgetting counts left by dead ones.
+// ARGV[1] is the instance key prefix and ARGV[2] this instance, whose heartbeat isn't checked.
+const sessionsScript = `
+local total = 0
+local fields = redis.call('HGETALL', KEYS[1])
+for i = 1, #fields, 2 do
+	local instance, count = fields[i], tonumber(fields[i + 1])
+	if instance ~= ARGV[2] and redis.call('EXISTS', ARGV[1] .. instance) == 0 then
+		redis.call('HDEL', KEYS[1], instance)
+	elseif count > 0 then
+		total = total + count
+	end
+end
+return total`
+
+// sessions counts a user's sessions in one round trip, however many instances hold them
+func (h *Redis) sessions(userID int) (int, error) {
+	reply, err := h.do("EVAL", sessionsScript, "1", h.presenceKey(userID), h.instanceKey(""), h.instance)
+	if err != nil {
+		return 0, err
+	}
+	total, _ := reply.(int64)
+	return int(total), nil
+}
+
+// Close stops the background work and withdraws this instance, so its sessions stop counting
+func (h *Redis) Close() error {
+	close(h.done)
+
+	h.subMu.Lock()
+	if h.sub != nil {
+		h.sub.close()
+	}
+	h.subMu.Unlock()
+	h.wg.Wait()
+
+	_, err := h.do("DEL", h.instanceKey(h.instance))
+
+	h.mu.Lock()
+	if h.cmd != nil {
+		h.cmd.close()
+		h.cmd = nil
+	}
+	h.mu.Unlock()
+	return err
+}
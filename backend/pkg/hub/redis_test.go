@@ -0,0 +1,353 @@
+package hub
+
+import (
+	"backend/config"
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process stand-in for a Redis server, speaking just the commands the hub
+// sends. Lua isn't available, so each script the hub uses is run by a Go equivalent.
+type fakeRedis struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	strings map[string]string
+	hashes  map[string]map[string]int64
+	subs    map[string][]*fakeConn
+}
+
+type fakeConn struct {
+	*respConn
+	mu sync.Mutex // guards writes, which publishes from other connections share
+}
+
+// status is a simple string reply, as opposed to a bulk one
+type status string
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		ln:      ln,
+		strings: make(map[string]string),
+		hashes:  make(map[string]map[string]int64),
+		subs:    make(map[string][]*fakeConn),
+	}
+	go f.serve()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &fakeConn{respConn: &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}}
+		go f.handle(c)
+	}
+}
+
+func (f *fakeRedis) handle(c *fakeConn) {
+	defer c.close()
+	for {
+		req, err := c.read()
+		if err != nil {
+			return
+		}
+		items, _ := req.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		c.reply(f.run(c, strings.ToUpper(args[0]), args[1:]))
+	}
+}
+
+func (f *fakeRedis) run(c *fakeConn, cmd string, args []string) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch cmd {
+	case "AUTH":
+		return status("OK")
+	case "SET":
+		f.strings[args[0]] = args[1]
+		return status("OK")
+	case "DEL":
+		n := int64(0)
+		for _, key := range args {
+			if _, ok := f.strings[key]; ok {
+				delete(f.strings, key)
+				n++
+			}
+		}
+		return n
+	case "EXISTS":
+		return f.exists(args[0])
+	case "HINCRBY":
+		by, _ := strconv.ParseInt(args[2], 10, 64)
+		return f.hincrby(args[0], args[1], by)
+	case "HDEL":
+		return f.hdel(args[0], args[1])
+	case "HGETALL":
+		var reply []interface{}
+		for field, count := range f.hashes[args[0]] {
+			reply = append(reply, field, strconv.FormatInt(count, 10))
+		}
+		return reply
+	case "PUBLISH":
+		for _, sub := range f.subs[args[0]] {
+			sub.reply([]interface{}{"message", args[0], args[1]})
+		}
+		return int64(len(f.subs[args[0]]))
+	case "SUBSCRIBE":
+		f.subs[args[0]] = append(f.subs[args[0]], c)
+		return []interface{}{"subscribe", args[0], int64(1)}
+	case "EVAL":
+		numKeys, _ := strconv.Atoi(args[1])
+		keys, argv := args[2:2+numKeys], args[2+numKeys:]
+		switch args[0] {
+		case disconnectScript:
+			n := f.hincrby(keys[0], argv[0], -1)
+			if n <= 0 {
+				f.hdel(keys[0], argv[0])
+			}
+			return n
+		case sessionsScript:
+			total := int64(0)
+			for instance, count := range f.hashes[keys[0]] {
+				if instance != argv[1] && f.exists(argv[0]+instance) == 0 {
+					f.hdel(keys[0], instance)
+				} else if count > 0 {
+					total += count
+				}
+			}
+			return total
+		}
+		return respError("NOSCRIPT unknown script")
+	}
+	return respError("ERR unknown command " + cmd)
+}
+
+func (f *fakeRedis) exists(key string) int64 {
+	if _, ok := f.strings[key]; ok {
+		return 1
+	}
+	return 0
+}
+
+func (f *fakeRedis) hincrby(key, field string, by int64) int64 {
+	if f.hashes[key] == nil {
+		f.hashes[key] = make(map[string]int64)
+	}
+	f.hashes[key][field] += by
+	return f.hashes[key][field]
+}
+
+func (f *fakeRedis) hdel(key, field string) int64 {
+	if _, ok := f.hashes[key][field]; !ok {
+		return 0
+	}
+	delete(f.hashes[key], field)
+	return 1
+}
+
+func (f *fakeRedis) fields(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.hashes[key])
+}
+
+func (f *fakeRedis) subscribers(channel string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs[channel])
+}
+
+func (c *fakeConn) reply(v interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeReply(c.w, v)
+	c.w.Flush()
+}
+
+func writeReply(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case status:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case respError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
+
+// delivery is one frame handed to a recorder
+type delivery struct {
+	userID  int
+	groupID int
+	frame   Frame
+}
+
+type recorder chan delivery
+
+func (r recorder) DeliverToUser(userID int, f Frame)   { r <- delivery{userID: userID, frame: f} }
+func (r recorder) DeliverToGroup(groupID int, f Frame) { r <- delivery{groupID: groupID, frame: f} }
+
+func (r recorder) next(t *testing.T) delivery {
+	t.Helper()
+	select {
+	case d := <-r:
+		return d
+	case <-time.After(2 * time.Second):
+		t.Fatal("no frame delivered")
+		return delivery{}
+	}
+}
+
+func (r recorder) none(t *testing.T) {
+	t.Helper()
+	select {
+	case d := <-r:
+		t.Fatalf("unexpected frame %+v", d)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// startInstances connects n hubs to the fake server and waits until they all listen
+func startInstances(t *testing.T, f *fakeRedis, n int) ([]*Redis, []recorder) {
+	t.Helper()
+	cfg := config.HubConfig{Driver: "redis", Address: f.ln.Addr().String(), Prefix: "test:"}
+
+	var hubs []*Redis
+	var locals []recorder
+	for i := 0; i < n; i++ {
+		local := make(recorder, 16)
+		h, err := NewRedis(cfg, local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { h.Close() })
+		hubs = append(hubs, h)
+		locals = append(locals, local)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for f.subscribers(hubs[0].channel()) < n {
+		if time.Now().After(deadline) {
+			t.Fatal("instances never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return hubs, locals
+}
+
+func TestRedisPublishToUser(t *testing.T) {
+	hubs, locals := startInstances(t, startFakeRedis(t), 2)
+
+	if err := hubs[0].PublishToUser(5, Frame{Type: "private", ID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, local := range locals {
+		if d := local.next(t); d.userID != 5 || d.frame.ID != "a" {
+			t.Errorf("instance %d got %+v, want frame a for user 5", i, d)
+		}
+	}
+	// The publisher delivered locally already and must skip its own echo
+	locals[0].none(t)
+}
+
+func TestRedisPublishToGroup(t *testing.T) {
+	hubs, locals := startInstances(t, startFakeRedis(t), 2)
+
+	if err := hubs[1].PublishToGroup(3, Frame{Type: "group", ID: "b", ExceptUserID: 9}); err != nil {
+		t.Fatal(err)
+	}
+	for i, local := range locals {
+		d := local.next(t)
+		if d.groupID != 3 || d.frame.ID != "b" || d.frame.ExceptUserID != 9 {
+			t.Errorf("instance %d got %+v, want frame b for group 3", i, d)
+		}
+	}
+	locals[1].none(t)
+}
+
+func TestRedisConnectedDisconnected(t *testing.T) {
+	f := startFakeRedis(t)
+	hubs, _ := startInstances(t, f, 2)
+
+	steps := []struct {
+		name string
+		call func(int) (bool, error)
+		want bool
+	}{
+		{"first session", hubs[0].Connected, true},
+		{"second session elsewhere", hubs[1].Connected, false},
+		{"third session", hubs[0].Connected, false},
+		{"one of two on instance 0 ends", hubs[0].Disconnected, false},
+		{"instance 0 empties", hubs[0].Disconnected, false},
+		{"last session ends", hubs[1].Disconnected, true},
+	}
+	for _, step := range steps {
+		got, err := step.call(1)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: got %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	if online, _ := hubs[0].Online(1); online {
+		t.Error("user still online after every session ended")
+	}
+	if n := f.fields(hubs[0].presenceKey(1)); n != 0 {
+		t.Errorf("%d presence fields left behind", n)
+	}
+}
+
+func TestRedisForgetsDeadInstances(t *testing.T) {
+	f := startFakeRedis(t)
+	hubs, _ := startInstances(t, f, 2)
+
+	if _, err := hubs[1].Connected(1); err != nil {
+		t.Fatal(err)
+	}
+	if online, _ := hubs[0].Online(1); !online {
+		t.Fatal("user on a live instance is offline")
+	}
+
+	// The instance's heartbeat key goes, as when it dies and the key expires
+	f.mu.Lock()
+	delete(f.strings, hubs[1].instanceKey(hubs[1].instance))
+	f.mu.Unlock()
+
+	if online, _ := hubs[0].Online(1); online {
+		t.Error("sessions of a dead instance still count")
+	}
+	if n := f.fields(hubs[0].presenceKey(1)); n != 0 {
+		t.Errorf("dead instance's field kept: %d fields", n)
+	}
+}
@@ -1,6 +1,7 @@
 package post
 
 import (
+	"backend/config"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,6 +12,11 @@ import (
 
 var uploadDir = "uploads"
 
+// Configure sets the directory SaveFile writes to
+func Configure(uploads config.UploadsConfig) {
+	uploadDir = uploads.Dir
+}
+
 // File uploader
 func SaveFile(r *http.Request, fieldName string) (string, error) {
 	file, header, err := r.FormFile(fieldName)
@@ -80,10 +80,15 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, _ := GenerateJWT(userID, req.Email)
+	sessionID, refreshToken, err := CreateSession(userID, r)
+	if err != nil {
+		log.Printf("[Login] Session creation failed for id=%d: %v", userID, err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
 	log.Printf("[Login] User %d logged in", userID)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	writeTokenPair(w, userID, req.Email, sessionID, refreshToken)
 }
 
 func GetAllUsersHandler(w http.ResponseWriter, r *http.Request) {
@@ -119,12 +124,13 @@ func UploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("[Avatar] Token received: %s\n", token)
 
-	userID, err := ExtractUserIDFromToken(token)
+	claims, err := AuthenticateToken(token)
 	if err != nil {
 		log.Printf("[Avatar][ERROR] Invalid token: %v\n", err)
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
+	userID := claims.UserID
 	log.Printf("[Avatar] Extracted userID: %d\n", userID)
 
 	// Parse up to 10MB file
@@ -268,12 +274,13 @@ func GetFullUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := ExtractUserIDFromToken(token)
+	claims, err := AuthenticateToken(token)
 	if err != nil {
 		log.Printf("[Profile][ERROR] Invalid token: %v", err)
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
+	userID := claims.UserID
 
 	var user User
 	err = db.Instance.QueryRow(`
@@ -311,12 +318,13 @@ func UpdateUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := ExtractUserIDFromToken(token)
+	claims, err := AuthenticateToken(token)
 	if err != nil {
 		log.Printf("[Update][ERROR] Invalid token: %v", err)
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
+	userID := claims.UserID
 
 	var updateData struct {
 		FirstName   *string `json:"first_name,omitempty"`
@@ -1,11 +1,11 @@
 package user
 
 import (
+	"backend/config"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,10 +13,19 @@ import (
 	"time"
 )
 
-// -------------------- Constants --------------------
-const AvatarDir = "./uploads/avatars"
+// -------------------- Config --------------------
 
-var JwtSecret = []byte("love-love-love")
+// AvatarDir is where UploadAvatarHandler stores avatars, overridden by Configure
+var AvatarDir = "./uploads/avatars"
+
+// Configure applies the auth and upload settings; call it before serving requests
+func Configure(auth config.AuthConfig, uploads config.UploadsConfig) {
+	AvatarDir = uploads.AvatarDir
+	TokenIssuer = auth.Issuer
+	TokenAudience = auth.Audience
+	AccessTokenTTL = auth.AccessTokenTTL.Duration
+	RefreshTokenTTL = auth.RefreshTokenTTL.Duration
+}
 
 // -------------------- Models --------------------
 type User struct {
@@ -53,56 +62,41 @@ func Base64Decode(data string) ([]byte, error) {
 	return base64.RawURLEncoding.DecodeString(data)
 }
 
-func GenerateJWT(userID int, email string) (string, error) {
-	header := map[string]string{"alg": "HS256", "typ": "JWT"}
-	payload := map[string]interface{}{
-		"id":    userID,
-		"email": email,
-		"exp":   time.Now().Add(24 * time.Hour).Unix(),
+func GenerateJWT(userID int, email, sessionID string) (string, error) {
+	kid, secret, err := Keys.Active()
+	if err != nil {
+		return "", err
 	}
 
-	headerJSON, _ := json.Marshal(header)
-	payloadJSON, _ := json.Marshal(payload)
-
-	headerEnc := Base64Encode(headerJSON)
-	payloadEnc := Base64Encode(payloadJSON)
-	signature := Base64Encode(SignHMACSHA256([]byte(headerEnc+"."+payloadEnc), JwtSecret))
-
-	token := fmt.Sprintf("%s.%s.%s", headerEnc, payloadEnc, signature)
-	log.Printf("[JWT] Generated token for id=%d", userID)
-	return token, nil
-}
-
-func ExtractUserIDFromToken(token string) (int, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) != 3 {
-		return 0, errors.New("invalid token format")
+	now := time.Now()
+	header := jwtHeader{Alg: "HS256", Typ: "JWT", Kid: kid}
+	payload := Claims{
+		UserID:    userID,
+		Email:     email,
+		SessionID: sessionID,
+		Issuer:    TokenIssuer,
+		Audience:  TokenAudience,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(AccessTokenTTL).Unix(),
 	}
 
-	headerPayload := parts[0] + "." + parts[1]
-	sig, err := Base64Decode(parts[2])
+	headerJSON, err := json.Marshal(header)
 	if err != nil {
-		return 0, err
+		return "", err
 	}
-
-	if !VerifyHMACSHA256([]byte(headerPayload), JwtSecret, sig) {
-		return 0, errors.New("invalid token signature")
-	}
-
-	payloadJSON, err := Base64Decode(parts[1])
+	payloadJSON, err := json.Marshal(payload)
 	if err != nil {
-		return 0, err
+		return "", err
 	}
 
-	var claims map[string]interface{}
-	if err := json.Unmarshal(payloadJSON, &claims); err != nil {
-		return 0, err
-	}
+	headerEnc := Base64Encode(headerJSON)
+	payloadEnc := Base64Encode(payloadJSON)
+	signature := Base64Encode(SignHMACSHA256([]byte(headerEnc+"."+payloadEnc), secret))
 
-	if uid, ok := claims["id"].(float64); ok {
-		return int(uid), nil
-	}
-	return 0, errors.New("id not found")
+	token := fmt.Sprintf("%s.%s.%s", headerEnc, payloadEnc, signature)
+	log.Printf("[JWT] Generated token for id=%d (kid=%s)", userID, kid)
+	return token, nil
 }
 
 // Middleware for JWT auth
@@ -114,48 +108,19 @@ func JwtMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		email, err := ExtractEmailFromToken(tokenString)
+		claims, err := AuthenticateToken(tokenString)
 		if err != nil {
 			log.Printf("[JWT] Invalid token: %v", err)
+			if err == ErrSessionRevoked || err == ErrTokenExpired {
+				http.Error(w, "Session expired", http.StatusUnauthorized)
+				return
+			}
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		r.Header.Set("User-Email", email)
+		r.Header.Set("User-Email", claims.Email)
+		r.Header.Set("User-Session", claims.SessionID)
 		next(w, r)
 	}
 }
-
-// ExtractEmailFromToken extracts email from custom JWT
-func ExtractEmailFromToken(tokenString string) (string, error) {
-	parts := strings.Split(tokenString, ".")
-	if len(parts) != 3 {
-		return "", errors.New("invalid token format")
-	}
-
-	headerPayload := parts[0] + "." + parts[1]
-
-	sig, err := Base64Decode(parts[2])
-	if err != nil {
-		return "", errors.New("invalid token signature encoding")
-	}
-	if !VerifyHMACSHA256([]byte(headerPayload), JwtSecret, sig) {
-		return "", errors.New("invalid token signature")
-	}
-
-	payloadJSON, err := Base64Decode(parts[1])
-	if err != nil {
-		return "", errors.New("invalid token payload encoding")
-	}
-
-	var claims map[string]interface{}
-	if err := json.Unmarshal(payloadJSON, &claims); err != nil {
-		return "", errors.New("invalid token payload")
-	}
-
-	email, ok := claims["email"].(string)
-	if !ok {
-		return "", errors.New("email not found in token")
-	}
-	return email, nil
-}
@@ -0,0 +1,181 @@
+package user
+
+import (
+	"backend/config"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// -------------------- Constants --------------------
+
+// HS256 keys shorter than the hash output weaken the signature
+const minSigningKeyLength = 32
+
+var (
+	ErrUnknownKeyID  = errors.New("unknown signing key id")
+	ErrNoActiveKey   = errors.New("no active signing key configured")
+	ErrKeyTooShort   = fmt.Errorf("signing keys must be at least %d bytes", minSigningKeyLength)
+	ErrDuplicateKeys = errors.New("duplicate signing key id")
+)
+
+// -------------------- Models --------------------
+
+// SigningKey is one entry of the key file
+type SigningKey struct {
+	ID     string `json:"kid"`
+	Secret string `json:"secret"`
+}
+
+// KeyFile is the on-disk format of the signing keys.
+// New tokens are signed with ActiveKeyID; every key listed is accepted for verification,
+// so rotating means adding a new key, making it active, and dropping the old one once
+// its tokens have expired (AccessTokenTTL).
+type KeyFile struct {
+	ActiveKeyID string       `json:"active_kid"`
+	Keys        []SigningKey `json:"keys"`
+}
+
+// Keyring holds the signing keys used for issuing and verifying access tokens
+type Keyring struct {
+	mu       sync.RWMutex
+	activeID string
+	keys     map[string][]byte
+}
+
+// Keys is the keyring used by GenerateJWT and ParseToken, set up by InitKeys
+var Keys = &Keyring{keys: map[string][]byte{}}
+
+// -------------------- Keyring --------------------
+func (kr *Keyring) replace(kf KeyFile) error {
+	keys := make(map[string][]byte, len(kf.Keys))
+	for _, k := range kf.Keys {
+		if k.ID == "" {
+			return errors.New("signing key without kid")
+		}
+		if _, exists := keys[k.ID]; exists {
+			return fmt.Errorf("%w: %s", ErrDuplicateKeys, k.ID)
+		}
+		if len(k.Secret) < minSigningKeyLength {
+			return fmt.Errorf("%w (kid %s)", ErrKeyTooShort, k.ID)
+		}
+		keys[k.ID] = []byte(k.Secret)
+	}
+
+	if _, ok := keys[kf.ActiveKeyID]; !ok {
+		return ErrNoActiveKey
+	}
+
+	kr.mu.Lock()
+	kr.activeID = kf.ActiveKeyID
+	kr.keys = keys
+	kr.mu.Unlock()
+	return nil
+}
+
+// Active returns the key new tokens are signed with
+func (kr *Keyring) Active() (string, []byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	secret, ok := kr.keys[kr.activeID]
+	if !ok {
+		return "", nil, ErrNoActiveKey
+	}
+	return kr.activeID, secret, nil
+}
+
+// Lookup returns the key for a token's kid header
+func (kr *Keyring) Lookup(kid string) ([]byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	secret, ok := kr.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	return secret, nil
+}
+
+// -------------------- Loading --------------------
+func LoadKeyFile(path string) (KeyFile, error) {
+	var kf KeyFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return kf, err
+	}
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return kf, fmt.Errorf("parse key file %s: %w", path, err)
+	}
+	return kf, nil
+}
+
+// keyFileFromConfig builds the key set from the auth config: the key file takes
+// precedence, otherwise Secret/KeyID is the active key and PreviousKeys ("kid:secret")
+// are still accepted for verification.
+func keyFileFromConfig(cfg config.AuthConfig) (KeyFile, bool, error) {
+	if cfg.KeyFile != "" {
+		kf, err := LoadKeyFile(cfg.KeyFile)
+		return kf, true, err
+	}
+
+	if cfg.Secret == "" {
+		return KeyFile{}, false, nil
+	}
+
+	kf := KeyFile{ActiveKeyID: cfg.KeyID, Keys: []SigningKey{{ID: cfg.KeyID, Secret: cfg.Secret}}}
+	for _, pair := range cfg.PreviousKeys {
+		oldKid, oldSecret, ok := strings.Cut(pair, ":")
+		if !ok {
+			return kf, true, fmt.Errorf("previous key entry %q is not kid:secret", pair)
+		}
+		kf.Keys = append(kf.Keys, SigningKey{ID: oldKid, Secret: oldSecret})
+	}
+	return kf, true, nil
+}
+
+// InitKeys loads the signing keys into Keys. Without any configuration a random
+// key is generated, which is fine for development but logs everyone out on restart.
+func InitKeys(cfg config.AuthConfig) error {
+	kf, configured, err := keyFileFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	if !configured {
+		buf := make([]byte, minSigningKeyLength)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		log.Println("[JWT] WARNING: no signing key configured (JWT_KEY_FILE or JWT_SECRET), using an ephemeral key")
+		kf = KeyFile{ActiveKeyID: "ephemeral", Keys: []SigningKey{{ID: "ephemeral", Secret: Base64Encode(buf)}}}
+	}
+
+	if err := Keys.replace(kf); err != nil {
+		return err
+	}
+	log.Printf("[JWT] Loaded %d signing key(s), active kid=%s", len(kf.Keys), kf.ActiveKeyID)
+	return nil
+}
+
+// ReloadKeys re-reads the key configuration, e.g. after a key file was rotated.
+// On error the current keys stay in place.
+func ReloadKeys(cfg config.AuthConfig) error {
+	kf, configured, err := keyFileFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if !configured {
+		return errors.New("no signing key configuration to reload")
+	}
+	if err := Keys.replace(kf); err != nil {
+		return err
+	}
+	log.Printf("[JWT] Reloaded %d signing key(s), active kid=%s", len(kf.Keys), kf.ActiveKeyID)
+	return nil
+}
@@ -0,0 +1,294 @@
+package user
+
+import (
+	"backend/db"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// -------------------- Constants --------------------
+
+// Same layout SQLite's datetime('now') produces, so expiry checks can be done in SQL
+const sessionTimeLayout = "2006-01-02 15:04:05"
+
+// Token lifetimes, overridden by Configure. Access tokens stay long-lived while the web client
+// has no refresh flow; shorten them once it does.
+var (
+	AccessTokenTTL  = 24 * time.Hour
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// -------------------- Session Helpers --------------------
+func generateRandomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Only the hash of a refresh token is stored, never the token itself
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession starts a new session for the user and returns its ID and the first refresh token
+func CreateSession(userID int, r *http.Request) (string, string, error) {
+	sessionID, err := generateRandomToken()
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := generateRandomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now().UTC()
+	_, err = db.Instance.Exec(`
+		INSERT INTO sessions (session_id, user_id, refresh_token_hash, user_agent, ip_address, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		sessionID, userID, hashToken(refreshToken), r.UserAgent(), r.RemoteAddr,
+		now.Format(sessionTimeLayout), now.Format(sessionTimeLayout), now.Add(RefreshTokenTTL).Format(sessionTimeLayout))
+	if err != nil {
+		return "", "", err
+	}
+
+	log.Printf("[Session] Created session for user %d", userID)
+	return sessionID, refreshToken, nil
+}
+
+// RotateRefreshToken swaps a valid refresh token for a new one.
+// Presenting an already-rotated token revokes the whole session, since it means the token leaked.
+func RotateRefreshToken(refreshToken string) (int, string, string, error) {
+	newRefreshToken, err := generateRandomToken()
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	oldHash := hashToken(refreshToken)
+	newHash := hashToken(newRefreshToken)
+	now := time.Now().UTC()
+
+	// Single UPDATE so two concurrent refreshes with the same token can't both succeed
+	result, err := db.Instance.Exec(`
+		UPDATE sessions
+		SET previous_token_hash = refresh_token_hash, refresh_token_hash = ?, last_used_at = ?, expires_at = ?
+		WHERE refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > datetime('now')`,
+		newHash, now.Format(sessionTimeLayout), now.Add(RefreshTokenTTL).Format(sessionTimeLayout), oldHash)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	if rowsAffected == 0 {
+		var reusedSessionID string
+		err := db.Instance.QueryRow(`SELECT session_id FROM sessions WHERE previous_token_hash = ? AND revoked_at IS NULL`,
+			oldHash).Scan(&reusedSessionID)
+		if err == nil {
+			log.Printf("[Session] Refresh token reuse detected, revoking session %s", reusedSessionID)
+			RevokeSession(reusedSessionID)
+			return 0, "", "", ErrRefreshTokenReused
+		}
+		return 0, "", "", ErrInvalidRefreshToken
+	}
+
+	var userID int
+	var sessionID string
+	err = db.Instance.QueryRow(`SELECT user_id, session_id FROM sessions WHERE refresh_token_hash = ?`, newHash).
+		Scan(&userID, &sessionID)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	return userID, sessionID, newRefreshToken, nil
+}
+
+// IsSessionActive reports whether the session exists, is not revoked and has not expired
+func IsSessionActive(sessionID string) bool {
+	if sessionID == "" {
+		return false
+	}
+
+	var exists int
+	err := db.Instance.QueryRow(`
+		SELECT 1 FROM sessions
+		WHERE session_id = ? AND revoked_at IS NULL AND expires_at > datetime('now')`,
+		sessionID).Scan(&exists)
+
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("[Session] Lookup failed for session %s: %v", sessionID, err)
+	}
+	return err == nil && exists == 1
+}
+
+func RevokeSession(sessionID string) error {
+	_, err := db.Instance.Exec(`UPDATE sessions SET revoked_at = ? WHERE session_id = ? AND revoked_at IS NULL`,
+		time.Now().UTC().Format(sessionTimeLayout), sessionID)
+	return err
+}
+
+func RevokeAllSessions(userID int) (int64, error) {
+	result, err := db.Instance.Exec(`UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`,
+		time.Now().UTC().Format(sessionTimeLayout), userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// writeTokenPair issues an access token for the session and sends it alongside the refresh token
+func writeTokenPair(w http.ResponseWriter, userID int, email, sessionID, refreshToken string) {
+	token, err := GenerateJWT(userID, email, sessionID)
+	if err != nil {
+		log.Printf("[Session] Token generation failed: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(AccessTokenTTL.Seconds()),
+	})
+}
+
+// -------------------- Handlers --------------------
+
+// RefreshHandler exchanges a refresh token for a new access token and a rotated refresh token
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, sessionID, newRefreshToken, err := RotateRefreshToken(req.RefreshToken)
+	if err != nil {
+		log.Printf("[Refresh] Rejected refresh token: %v", err)
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	var email string
+	if err := db.Instance.QueryRow(`SELECT email FROM users WHERE id = ?`, userID).Scan(&email); err != nil {
+		log.Printf("[Refresh] User lookup failed for id=%d: %v", userID, err)
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	log.Printf("[Refresh] Rotated refresh token for user %d", userID)
+	writeTokenPair(w, userID, email, sessionID, newRefreshToken)
+}
+
+// LogoutHandler revokes the current session, identified by refresh token or by access token
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// Body is optional, the access token alone is enough
+	json.NewDecoder(r.Body).Decode(&req)
+
+	var sessionID string
+	if req.RefreshToken != "" {
+		err := db.Instance.QueryRow(`
+			SELECT session_id FROM sessions
+			WHERE refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > datetime('now')`,
+			hashToken(req.RefreshToken)).Scan(&sessionID)
+		if err != nil {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+	} else {
+		tokenString := r.Header.Get("Authorization")
+		if tokenString == "" {
+			http.Error(w, "Authorization header or refresh_token is required", http.StatusUnauthorized)
+			return
+		}
+
+		// Same checks as JwtMiddleware, so a token of an already revoked session is refused
+		claims, err := AuthenticateToken(tokenString)
+		if err != nil {
+			if err == ErrSessionRevoked || err == ErrTokenExpired {
+				http.Error(w, "Session expired", http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		sessionID = claims.SessionID
+	}
+
+	if err := RevokeSession(sessionID); err != nil {
+		log.Printf("[Logout] Revoke failed: %v", err)
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[Logout] Session %s revoked", sessionID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
+}
+
+// LogoutAllHandler revokes every session of the authenticated user
+func LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userEmail := r.Header.Get("User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	if err := db.Instance.QueryRow("SELECT id FROM users WHERE email = ?", userEmail).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	count, err := RevokeAllSessions(userID)
+	if err != nil {
+		log.Printf("[Logout] Revoke all failed for user %d: %v", userID, err)
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[Logout] Revoked %d sessions for user %d", count, userID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Logged out of all sessions",
+		"count":   count,
+	})
+}
@@ -0,0 +1,139 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// -------------------- Constants --------------------
+
+// Tolerated clock drift when checking exp, nbf and iat
+const tokenLeeway = 30 * time.Second
+
+// Expected iss/aud claims, overridden by Configure
+var (
+	TokenIssuer   = "real-time-forum"
+	TokenAudience = "real-time-forum-api"
+)
+
+var (
+	ErrMalformedToken    = errors.New("invalid token format")
+	ErrUnsupportedAlg    = errors.New("unsupported token algorithm")
+	ErrInvalidSignature  = errors.New("invalid token signature")
+	ErrTokenExpired      = errors.New("token has expired")
+	ErrTokenNotYetValid  = errors.New("token is not valid yet")
+	ErrTokenIssuedFuture = errors.New("token issued in the future")
+	ErrInvalidIssuer     = errors.New("invalid token issuer")
+	ErrInvalidAudience   = errors.New("invalid token audience")
+	ErrMissingClaims     = errors.New("token is missing required claims")
+	ErrSessionRevoked    = errors.New("session revoked or expired")
+)
+
+// -------------------- Models --------------------
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// Claims is the payload of the access tokens issued by GenerateJWT
+type Claims struct {
+	UserID    int    `json:"id"`
+	Email     string `json:"email"`
+	SessionID string `json:"sid"`
+	Issuer    string `json:"iss"`
+	Audience  string `json:"aud"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// -------------------- Validation --------------------
+
+// ParseToken verifies the token's header, signature and standard claims and returns its claims.
+// A leading "Bearer " is accepted so callers can pass the Authorization header as is.
+func ParseToken(tokenString string) (*Claims, error) {
+	tokenString = strings.TrimSpace(strings.TrimPrefix(tokenString, "Bearer "))
+
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	headerJSON, err := Base64Decode(parts[0])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, ErrMalformedToken
+	}
+	// Only HS256 is ever issued; anything else ("none" included) is rejected before the signature check
+	if header.Alg != "HS256" || (header.Typ != "" && header.Typ != "JWT") {
+		return nil, ErrUnsupportedAlg
+	}
+
+	secret, err := Keys.Lookup(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := Base64Decode(parts[2])
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if !VerifyHMACSHA256([]byte(parts[0]+"."+parts[1]), secret, sig) {
+		return nil, ErrInvalidSignature
+	}
+
+	payloadJSON, err := Base64Decode(parts[1])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payloadJSON, &claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if err := claims.validate(time.Now()); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (c *Claims) validate(now time.Time) error {
+	if c.UserID <= 0 || c.Email == "" || c.ExpiresAt == 0 || c.IssuedAt == 0 {
+		return ErrMissingClaims
+	}
+	if now.After(time.Unix(c.ExpiresAt, 0).Add(tokenLeeway)) {
+		return ErrTokenExpired
+	}
+	if c.NotBefore != 0 && now.Add(tokenLeeway).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrTokenNotYetValid
+	}
+	if now.Add(tokenLeeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return ErrTokenIssuedFuture
+	}
+	if c.Issuer != TokenIssuer {
+		return ErrInvalidIssuer
+	}
+	if c.Audience != TokenAudience {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+// AuthenticateToken is ParseToken plus a check that the token's session is still active.
+// Every request-level authentication should go through it.
+func AuthenticateToken(tokenString string) (*Claims, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if !IsSessionActive(claims.SessionID) {
+		return nil, ErrSessionRevoked
+	}
+	return claims, nil
+}
@@ -89,6 +89,18 @@ export function WebSocketProvider({ children }: { children: ReactNode }) {
               );
               break;
 
+            case "presence":
+              // online_users is only the snapshot on connect; each change comes as presence
+              setOnlineUsers((prev) => {
+                const others = prev.filter((id) => id !== msg.user_id);
+                return msg.online ? [...others, msg.user_id] : others;
+              });
+              // Status text and last seen are for the listeners
+              window.dispatchEvent(
+                new CustomEvent("websocket-message", { detail: msg })
+              );
+              break;
+
             case "notification":
               if (msg.notification) {
                 console.log("🔔 NOTIFICATION RECEIVED:", {
@@ -18,6 +18,12 @@ export function useWebSocket(token?: string) {
     ws.onmessage = (e) => {
       const msg = JSON.parse(e.data);
       if (msg.type === "online_users") setOnlineUsers(msg.online_users || []);
+      // After the first snapshot, changes arrive one user at a time
+      if (msg.type === "presence")
+        setOnlineUsers((p) => {
+          const others = p.filter((id) => id !== msg.user_id);
+          return msg.online ? [...others, msg.user_id] : others;
+        });
       if (msg.type === "notification") setNotifications((p) => [msg.notification, ...p]);
       // handle messages too if needed
     };